@@ -3,11 +3,17 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin" // for message broadcasting
+	"github.com/google/uuid"
 
 	"nunet/app/shared"
 	"nunet/pkg"
@@ -15,7 +21,7 @@ import (
 
 // handleHealthRequest returns health information about the node
 func (a *api) handleHealthRequest(c *gin.Context) {
-	availableCompute, err := pkg.GetComputeAvailable()
+	availableCompute, err := a.Job.NodeCompute()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  "error",
@@ -57,10 +63,27 @@ func (a *api) handleHealthRequest(c *gin.Context) {
 
 // handleDeploymentRequest handles incoming deployment requests
 func (a *api) handleDeploymentRequest(c *gin.Context) {
-	var request shared.ApiDeployRequest
+	jobID := uuid.NewString()
+
+	// Input files are staged as the body is read; drop them unless the job is sent
+	sent := false
+	defer func() {
+		if !sent {
+			a.Job.DiscardInputs(jobID)
+		}
+	}()
 
 	// Decode request body and handle bad request
-	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
+	request, inputs, err := a.readDeployRequest(c, jobID)
+	if errors.Is(err, shared.ErrFilesTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"status":  "error",
+			"error":   "Invalid request",
+			"details": err.Error(),
+		})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"status":  "error",
 			"error":   "Invalid request",
@@ -79,6 +102,38 @@ func (a *api) handleDeploymentRequest(c *gin.Context) {
 		return
 	}
 
+	// Files referenced by cid travel along with the uploaded ones
+	for _, file := range request.Inputs {
+		input, err := a.Job.ReferenceInput(jobID, file)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  "error",
+				"error":   "Invalid request",
+				"details": err.Error(),
+			})
+			return
+		}
+		inputs = append(inputs, input)
+	}
+	if err := shared.ValidateFiles(inputs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"error":   "Invalid request",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	wait, timeout, err := parseWaitOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"error":   "Invalid request",
+			"details": err.Error(),
+		})
+		return
+	}
+
 	// Check for available peers and handle no peers scenario
 	peers := a.Job.ListPeers()
 	if len(peers) == 0 {
@@ -92,6 +147,17 @@ func (a *api) handleDeploymentRequest(c *gin.Context) {
 
 	fmt.Printf("Received api request: %s %s\n", request.Program, strings.Join(request.Arguments, " "))
 
+	// Pick the peer to run the job on
+	target, err := a.Job.SelectPeer(request.Runtime, request.Resources)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  "error",
+			"error":   "No peer can run the job",
+			"details": err.Error(),
+		})
+		return
+	}
+
 	addrs, err := a.P2P.ListAddresses()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -102,28 +168,147 @@ func (a *api) handleDeploymentRequest(c *gin.Context) {
 		return
 	}
 
-	// Publish deployment request to pubsub topic
-	if err := a.Job.PublishDeploymentRequest(context.Background(), shared.DeployRequest{
+	// Send deployment request to the target peer
+	jobID, err = a.Job.SendDeploymentRequest(c.Request.Context(), shared.DeployRequest{
+		JobID:        jobID,
 		SourcePeerID: a.P2P.PeerID().String(),
 		SourceAddrs:  addrs,
+		Runtime:      request.Runtime,
 		Program:      request.Program,
 		Arguments:    request.Arguments,
-		TargetPeerID: peers[0].String(), // send to first peer
-	}); err != nil {
+		Resources:    request.Resources,
+		Env:          request.Env,
+		WorkDir:      request.WorkDir,
+		Stdin:        request.Stdin,
+		Inputs:       inputs,
+		Artifacts:    request.Artifacts,
+		RetryFailed:  request.RetryFailed,
+		TargetPeerID: target.String(),
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":  "error",
-			"error":   "Error publishing request",
+			"error":   "Error sending request",
 			"details": err.Error(),
 		})
 		return
 	}
+	sent = true
+
+	if !wait {
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "success",
+			"message": "Job request sent",
+			"data": gin.H{
+				"job_id": jobID,
+			},
+		})
+		return
+	}
+
+	// Block until the target peer reports back or we give up
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+
+	job, err := a.Job.WaitForJob(ctx, jobID)
+	if err != nil {
+		c.JSON(http.StatusGatewayTimeout, gin.H{
+			"status":  "error",
+			"error":   "Timed out waiting for job result",
+			"details": fmt.Sprintf("job %s is still %s, poll /jobs/%s for the result", jobID, job.Status, jobID),
+			"data":    job,
+		})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "success",
-		"message": "Job request sent",
+		"message": fmt.Sprintf("Job %s", job.Status),
+		"data":    job,
 	})
 }
 
+// readDeployRequest decodes a deploy request sent as JSON, or as a multipart form with the
+// request in a "request" field and input files in "files" fields. A file lands in the job's
+// working directory under its file name, or under <path> when sent in a "files:<path>" field.
+func (a *api) readDeployRequest(c *gin.Context, jobID string) (shared.ApiDeployRequest, []shared.File, error) {
+	var request shared.ApiDeployRequest
+	if c.ContentType() != "multipart/form-data" {
+		err := json.NewDecoder(c.Request.Body).Decode(&request)
+		return request, nil, err
+	}
+
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return request, nil, err
+	}
+
+	var inputs []shared.File
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return request, inputs, nil
+		}
+		if err != nil {
+			return request, inputs, err
+		}
+
+		name := part.FormName()
+		switch {
+		case name == "request":
+			if err := json.NewDecoder(part).Decode(&request); err != nil {
+				return request, inputs, fmt.Errorf("error decoding request field: %w", err)
+			}
+		case name == "files" || strings.HasPrefix(name, "files:"):
+			path := part.FileName()
+			if name != "files" {
+				path = strings.TrimPrefix(name, "files:")
+			}
+			input, err := a.Job.StageInput(jobID, path, part)
+			if err != nil {
+				return request, inputs, err
+			}
+			inputs = append(inputs, input)
+		}
+		part.Close()
+	}
+}
+
+const (
+	defaultWaitTimeout = time.Minute
+	maxWaitTimeout     = 10 * time.Minute
+)
+
+// parseWaitOptions reads the synchronous deploy options from the query string.
+// The timeout accepts either a Go duration ("90s") or a number of seconds.
+func parseWaitOptions(c *gin.Context) (bool, time.Duration, error) {
+	wait := false
+	if value := c.Query("wait"); value != "" {
+		var err error
+		if wait, err = strconv.ParseBool(value); err != nil {
+			return false, 0, fmt.Errorf("wait must be a boolean")
+		}
+	}
+
+	timeout := defaultWaitTimeout
+	if value := c.Query("timeout"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			seconds, convErr := strconv.Atoi(value)
+			if convErr != nil {
+				return false, 0, fmt.Errorf("timeout must be a duration or a number of seconds")
+			}
+			parsed = time.Duration(seconds) * time.Second
+		}
+		if parsed <= 0 || parsed > maxWaitTimeout {
+			return false, 0, fmt.Errorf("timeout must be between 1s and %s", maxWaitTimeout)
+		}
+		timeout = parsed
+	}
+
+	return wait, timeout, nil
+}
+
 func (a api) handleAddPeerRequest(c *gin.Context) {
 	var request shared.ApiAddPeerRequest
 	// Decode request body and handle bad request
@@ -161,3 +346,336 @@ func (a api) handleAddPeerRequest(c *gin.Context) {
 		"message": "Peer added",
 	})
 }
+
+// handleListPeersRequest returns the peers on the network along with the capabilities they advertised
+func (a *api) handleListPeersRequest(c *gin.Context) {
+	peers := a.Capability.ListCapabilities()
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Peers fetched",
+		"data": gin.H{
+			"peers":     peers,
+			"num_peers": len(peers),
+		},
+	})
+}
+
+const (
+	defaultJobsPageSize = 50
+	maxJobsPageSize     = 500
+)
+
+// handleListJobsRequest lists the jobs known to this node
+func (a *api) handleListJobsRequest(c *gin.Context) {
+	filter, err := parseJobFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"error":   "Invalid request",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	jobs, total := a.Job.ListJobs(filter)
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Jobs fetched",
+		"data": gin.H{
+			"jobs":   jobs,
+			"total":  total,
+			"limit":  filter.Limit,
+			"offset": filter.Offset,
+		},
+	})
+}
+
+// handleGetJobRequest returns a single job along with its response, if any
+func (a *api) handleGetJobRequest(c *gin.Context) {
+	job, ok := a.Job.GetJob(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  "error",
+			"error":   "Job not found",
+			"details": fmt.Sprintf("no job with id %s", c.Param("id")),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Job fetched",
+		"data":    job,
+	})
+}
+
+// handleGetJobOutputRequest returns the result reported by the peer that ran the job
+func (a *api) handleGetJobOutputRequest(c *gin.Context) {
+	job, ok := a.Job.GetJob(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  "error",
+			"error":   "Job not found",
+			"details": fmt.Sprintf("no job with id %s", c.Param("id")),
+		})
+		return
+	}
+
+	data := gin.H{
+		"job_id":         job.ID,
+		"job_status":     job.Status,
+		"target_peer_id": job.Request.TargetPeerID,
+		"pid":            0,
+		"exit_code":      nil,
+		"signal":         "",
+		"usage":          nil,
+		"outputs":        []pkg.OutputLine{},
+		"truncated":      false,
+		"err":            "",
+	}
+	if job.Response != nil {
+		data["target_peer_id"] = job.Response.TargetPeerID
+		data["pid"] = job.Response.PID
+		data["exit_code"] = job.Response.ExitCode
+		data["signal"] = job.Response.Signal
+		data["usage"] = job.Response.Usage
+		data["outputs"] = job.Response.Outputs
+		data["truncated"] = job.Response.OutputTruncated
+		data["err"] = job.Response.Err
+	}
+
+	message := "Job output fetched"
+	if !job.Status.IsTerminal() {
+		message = "Job has not finished yet"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": message,
+		"data":    data,
+	})
+}
+
+// handleListArtifactsRequest lists the files a job brought back
+func (a *api) handleListArtifactsRequest(c *gin.Context) {
+	job, ok := a.Job.GetJob(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  "error",
+			"error":   "Job not found",
+			"details": fmt.Sprintf("no job with id %s", c.Param("id")),
+		})
+		return
+	}
+
+	data := gin.H{
+		"job_id":        job.ID,
+		"job_status":    job.Status,
+		"artifacts":     []shared.File{},
+		"artifacts_err": "",
+	}
+	if job.Response != nil {
+		if job.Response.Artifacts != nil {
+			data["artifacts"] = job.Response.Artifacts
+		}
+		data["artifacts_err"] = job.Response.ArtifactsErr
+	}
+
+	message := "Job artifacts fetched"
+	if !job.Status.IsTerminal() {
+		message = "Job has not finished yet"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": message,
+		"data":    data,
+	})
+}
+
+// handleGetArtifactRequest downloads one of the files a job brought back
+func (a *api) handleGetArtifactRequest(c *gin.Context) {
+	name := strings.TrimPrefix(c.Param("name"), "/")
+	file, artifact, err := a.Job.OpenArtifact(c.Param("id"), name)
+	if errors.Is(err, shared.ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  "error",
+			"error":   "Job not found",
+			"details": fmt.Sprintf("no job with id %s", c.Param("id")),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  "error",
+			"error":   "Artifact not found",
+			"details": err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+
+	c.DataFromReader(http.StatusOK, artifact.Size, "application/octet-stream", file, map[string]string{
+		"Content-Disposition": fmt.Sprintf("attachment; filename=%q", path.Base(artifact.Path)),
+		"ETag":                fmt.Sprintf("%q", artifact.CID),
+	})
+}
+
+const logsHeartbeatInterval = 15 * time.Second
+
+// handleJobLogsRequest returns the output of a job. With follow=true the output is
+// streamed as server-sent events until the job ends.
+func (a *api) handleJobLogsRequest(c *gin.Context) {
+	id := c.Param("id")
+
+	follow := false
+	if value := c.Query("follow"); value != "" {
+		var err error
+		if follow, err = strconv.ParseBool(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  "error",
+				"error":   "Invalid request",
+				"details": "follow must be a boolean",
+			})
+			return
+		}
+	}
+
+	// Resume after the last entry an EventSource saw before reconnecting
+	from := c.Query("from")
+	if lastID := c.GetHeader("Last-Event-ID"); lastID != "" {
+		if seq, err := strconv.Atoi(lastID); err == nil {
+			from = strconv.Itoa(seq + 1)
+		}
+	}
+	seq := 0
+	if from != "" {
+		var err error
+		if seq, err = strconv.Atoi(from); err != nil || seq < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  "error",
+				"error":   "Invalid request",
+				"details": "from must be a non-negative integer",
+			})
+			return
+		}
+	}
+
+	entries, done, more, err := a.Job.ReadLogs(id, seq)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":  "error",
+			"error":   "Job not found",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if !follow {
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "success",
+			"message": "Job logs fetched",
+			"data": gin.H{
+				"job_id":  id,
+				"entries": entries,
+				"done":    done,
+			},
+		})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+
+	heartbeat := time.NewTicker(logsHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		for _, entry := range entries {
+			data, _ := json.Marshal(entry)
+			fmt.Fprintf(c.Writer, "id: %d\nevent: log\ndata: %s\n\n", entry.Seq, data)
+		}
+		seq += len(entries)
+
+		if done {
+			job, _ := a.Job.GetJob(id)
+			data, _ := json.Marshal(gin.H{"job_id": id, "job_status": job.Status})
+			fmt.Fprintf(c.Writer, "event: end\ndata: %s\n\n", data)
+			c.Writer.Flush()
+			return
+		}
+		c.Writer.Flush()
+
+		select {
+		case <-more:
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": heartbeat\n\n")
+		case <-c.Request.Context().Done():
+			return
+		}
+
+		entries, done, more, _ = a.Job.ReadLogs(id, seq)
+	}
+}
+
+// handleCancelJobRequest asks the peer running the job to stop it
+func (a *api) handleCancelJobRequest(c *gin.Context) {
+	id := c.Param("id")
+	if err := a.Job.CancelJob(id); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, shared.ErrJobNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, shared.ErrJobFinished):
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{
+			"status":  "error",
+			"error":   "Error cancelling job",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":  "success",
+		"message": "Job cancellation requested",
+		"data": gin.H{
+			"job_id": id,
+		},
+	})
+}
+
+// parseJobFilter reads job listing filters and pagination from the query string
+func parseJobFilter(c *gin.Context) (shared.JobFilter, error) {
+	filter := shared.JobFilter{
+		Status:       shared.JobStatus(c.Query("status")),
+		TargetPeerID: c.Query("target"),
+		Limit:        defaultJobsPageSize,
+	}
+
+	var err error
+	if since := c.Query("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return filter, fmt.Errorf("since must be an RFC3339 timestamp: %w", err)
+		}
+	}
+	if until := c.Query("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return filter, fmt.Errorf("until must be an RFC3339 timestamp: %w", err)
+		}
+	}
+	if limit := c.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 1 || filter.Limit > maxJobsPageSize {
+			return filter, fmt.Errorf("limit must be between 1 and %d", maxJobsPageSize)
+		}
+	}
+	if offset := c.Query("offset"); offset != "" {
+		if filter.Offset, err = strconv.Atoi(offset); err != nil || filter.Offset < 0 {
+			return filter, fmt.Errorf("offset must be a non-negative integer")
+		}
+	}
+	return filter, nil
+}
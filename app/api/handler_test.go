@@ -0,0 +1,347 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nunet/app/blob"
+	"nunet/app/shared"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// fakeJobs stands in for the job service; calls the tests don't expect panic on the nil interface
+type fakeJobs struct {
+	JobOperations
+	filter   shared.JobFilter
+	response *shared.DeployResponse
+
+	mu      sync.Mutex
+	entries []shared.LogEntry
+	done    bool
+	more    chan struct{}
+
+	staged    map[string]string // uploaded content by path
+	sent      *shared.DeployRequest
+	discarded bool
+}
+
+// fakePeers stands in for the p2p service
+type fakePeers struct {
+	PeerOperations
+}
+
+func (fakePeers) ListAddresses() ([]string, error) { return []string{"/ip4/127.0.0.1/tcp/4001"}, nil }
+func (fakePeers) PeerID() peer.ID                  { return "submitter" }
+
+func (f *fakeJobs) GetJob(id string) (shared.JobRecord, bool) {
+	return shared.JobRecord{ID: id, Status: shared.JobStatusSucceeded, Response: f.response}, id == "job"
+}
+
+func (f *fakeJobs) ReadLogs(id string, seq int) ([]shared.LogEntry, bool, <-chan struct{}, error) {
+	if id != "job" {
+		return nil, false, nil, fmt.Errorf("no job with id %s", id)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.more == nil {
+		f.more = make(chan struct{})
+	}
+	return append([]shared.LogEntry(nil), f.entries[seq:]...), f.done, f.more, nil
+}
+
+func (f *fakeJobs) StageInput(jobID, path string, r io.Reader) (shared.File, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return shared.File{}, err
+	}
+	if len(data) > 8 {
+		return shared.File{}, shared.ErrFilesTooLarge
+	}
+	if f.staged == nil {
+		f.staged = make(map[string]string)
+	}
+	f.staged[path] = string(data)
+	return shared.File{Path: path, Size: int64(len(data)), CID: blob.Sum(data).String()}, nil
+}
+
+func (f *fakeJobs) ReferenceInput(jobID string, file shared.File) (shared.File, error) {
+	return file, nil
+}
+
+func (f *fakeJobs) DiscardInputs(jobID string) {
+	f.discarded = true
+}
+
+func (f *fakeJobs) ListPeers() []peer.ID {
+	return []peer.ID{"executor"}
+}
+
+func (f *fakeJobs) SelectPeer(runtime string, resources shared.Resources) (peer.ID, error) {
+	return "executor", nil
+}
+
+func (f *fakeJobs) SendDeploymentRequest(ctx context.Context, request shared.DeployRequest) (string, error) {
+	f.sent = &request
+	return request.JobID, nil
+}
+
+// log appends an entry, ending the job when done, and wakes up followers
+func (f *fakeJobs) log(text string, done bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries = append(f.entries, shared.LogEntry{Seq: len(f.entries), Stream: "stdout", Text: text})
+	f.done = done
+	if f.more != nil {
+		close(f.more)
+	}
+	f.more = make(chan struct{})
+}
+
+func (f *fakeJobs) ListJobs(filter shared.JobFilter) ([]shared.JobRecord, int) {
+	f.filter = filter
+	return nil, 0
+}
+
+func TestListJobsFilter(t *testing.T) {
+	jobs := &fakeJobs{}
+	router := NewApi(nil, jobs, nil).router()
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet,
+		"/jobs?status=running&target=peer-a&since=2024-01-02T03:04:05Z&limit=10&offset=20", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, shared.JobFilter{
+		Status:       shared.JobStatusRunning,
+		TargetPeerID: "peer-a",
+		Since:        time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Limit:        10,
+		Offset:       20,
+	}, jobs.filter)
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/jobs", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, defaultJobsPageSize, jobs.filter.Limit)
+
+	for _, query := range []string{"since=yesterday", "until=2024-01-02", "limit=0", "limit=501", "limit=x", "offset=-1"} {
+		recorder = httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/jobs?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, query)
+	}
+}
+
+func TestParseWaitOptions(t *testing.T) {
+	parse := func(query string) (bool, time.Duration, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/deploy?"+query, nil)
+		return parseWaitOptions(c)
+	}
+
+	wait, timeout, err := parse("")
+	assert.NoError(t, err)
+	assert.False(t, wait)
+	assert.Equal(t, defaultWaitTimeout, timeout)
+
+	wait, timeout, err = parse("wait=true&timeout=90s")
+	assert.NoError(t, err)
+	assert.True(t, wait)
+	assert.Equal(t, 90*time.Second, timeout)
+
+	_, timeout, err = parse("wait=1&timeout=30")
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, timeout)
+
+	for _, query := range []string{"wait=maybe", "timeout=soon", "timeout=0", "timeout=-5s", "timeout=11m"} {
+		_, _, err := parse(query)
+		assert.Error(t, err, query)
+	}
+}
+
+func TestJobLogs(t *testing.T) {
+	jobs := &fakeJobs{}
+	jobs.log("one", false)
+	jobs.log("two", false)
+	router := NewApi(nil, jobs, nil).router()
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/jobs/job/logs?from=1", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"text":"two"`)
+	assert.NotContains(t, recorder.Body.String(), `"text":"one"`)
+
+	for path, status := range map[string]int{
+		"/jobs/job/logs?follow=maybe": http.StatusBadRequest,
+		"/jobs/job/logs?from=-1":      http.StatusBadRequest,
+		"/jobs/other/logs":            http.StatusNotFound,
+	} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, status, recorder.Code, path)
+	}
+}
+
+func TestJobLogsFollow(t *testing.T) {
+	jobs := &fakeJobs{}
+	jobs.log("one", false)
+	jobs.log("two", false)
+	server := httptest.NewServer(NewApi(nil, jobs, nil).router())
+	defer server.Close()
+
+	// a reconnecting EventSource resumes after the last entry it saw
+	request, err := http.NewRequest(http.MethodGet, server.URL+"/jobs/job/logs?follow=true", nil)
+	assert.NoError(t, err)
+	request.Header.Set("Last-Event-ID", "0")
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+	events := bufio.NewReader(response.Body)
+	event := func() string {
+		var lines []string
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil || line == "\n" {
+				return strings.Join(lines, "")
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	assert.Contains(t, event(), "id: 1\nevent: log\n")
+
+	// entries logged while following are streamed as they come, then the end of the job
+	jobs.log("three", false)
+	assert.Contains(t, event(), `"text":"three"`)
+	jobs.log("four", true)
+	assert.Contains(t, event(), "id: 3\nevent: log\n")
+	assert.Equal(t, "event: end\ndata: {\"job_id\":\"job\",\"job_status\":\"succeeded\"}\n", event())
+	assert.Equal(t, "", event())
+}
+
+func TestListArtifacts(t *testing.T) {
+	jobs := &fakeJobs{}
+	router := NewApi(nil, jobs, nil).router()
+	list := func() (files []shared.File, artifactsErr string) {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/jobs/job/artifacts", nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		var body struct {
+			Data struct {
+				Artifacts    []shared.File `json:"artifacts"`
+				ArtifactsErr string        `json:"artifacts_err"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+		return body.Data.Artifacts, body.Data.ArtifactsErr
+	}
+
+	jobs.response = &shared.DeployResponse{Artifacts: []shared.File{{Path: "out.txt", Size: 1, CID: blob.Sum([]byte("x")).String()}}}
+	files, artifactsErr := list()
+	assert.Len(t, files, 1)
+	assert.Empty(t, artifactsErr)
+
+	// collecting can fail before any artifact is stored
+	jobs.response = &shared.DeployResponse{ArtifactsErr: "error collecting artifacts: permission denied"}
+	files, artifactsErr = list()
+	assert.Empty(t, files)
+	assert.Equal(t, "error collecting artifacts: permission denied", artifactsErr)
+}
+
+// deployForm builds a multipart deploy request out of the request field and files by field name
+func deployForm(t *testing.T, request string, files map[string]string) *http.Request {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	assert.NoError(t, form.WriteField("request", request))
+	for field, content := range files {
+		part, err := form.CreateFormFile(field, "upload.txt")
+		assert.NoError(t, err)
+		_, err = part.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, form.Close())
+
+	httpRequest := httptest.NewRequest(http.MethodPost, "/deploy", &body)
+	httpRequest.Header.Set("Content-Type", form.FormDataContentType())
+	return httpRequest
+}
+
+func TestDeployMultipart(t *testing.T) {
+	jobs := &fakeJobs{}
+	router := NewApi(fakePeers{}, jobs, nil).router()
+	ref := blob.Sum([]byte("earlier")).String()
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, deployForm(t,
+		`{"program": "cat", "arguments": ["upload.txt"], "inputs": [{"path": "ref.txt", "cid": "`+ref+`"}]}`,
+		map[string]string{"files": "hello", "files:data/in.txt": "world"}))
+	assert.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	assert.False(t, jobs.discarded)
+
+	// uploads land under their file name or the path in the field name, next to referenced files
+	assert.Equal(t, map[string]string{"upload.txt": "hello", "data/in.txt": "world"}, jobs.staged)
+	if assert.NotNil(t, jobs.sent) {
+		assert.Equal(t, "cat", jobs.sent.Program)
+		assert.Equal(t, peer.ID("submitter").String(), jobs.sent.SourcePeerID)
+		assert.Equal(t, peer.ID("executor").String(), jobs.sent.TargetPeerID)
+		assert.ElementsMatch(t, []shared.File{
+			{Path: "upload.txt", Size: 5, CID: blob.Sum([]byte("hello")).String()},
+			{Path: "data/in.txt", Size: 5, CID: blob.Sum([]byte("world")).String()},
+			{Path: "ref.txt", CID: ref},
+		}, jobs.sent.Inputs)
+	}
+
+	var body struct {
+		Data struct {
+			JobID string `json:"job_id"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	assert.NotEmpty(t, body.Data.JobID)
+	if jobs.sent != nil {
+		assert.Equal(t, jobs.sent.JobID, body.Data.JobID)
+	}
+}
+
+func TestDeployMultipartRejected(t *testing.T) {
+	for name, test := range map[string]struct {
+		request string
+		files   map[string]string
+		status  int
+	}{
+		"bad request field": {`{"program":`, nil, http.StatusBadRequest},
+		"invalid request":   {`{"arguments": ["x"]}`, map[string]string{"files": "hello"}, http.StatusBadRequest},
+		"clashing paths":    {`{"program": "cat"}`, map[string]string{"files": "a", "files:upload.txt": "b"}, http.StatusBadRequest},
+		"escaping path":     {`{"program": "cat"}`, map[string]string{"files:../x": "a"}, http.StatusBadRequest},
+		"too large":         {`{"program": "cat"}`, map[string]string{"files": "more than eight bytes"}, http.StatusRequestEntityTooLarge},
+	} {
+		jobs := &fakeJobs{}
+		router := NewApi(fakePeers{}, jobs, nil).router()
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, deployForm(t, test.request, test.files))
+		assert.Equal(t, test.status, recorder.Code, name)
+		assert.Nil(t, jobs.sent, name)
+		assert.True(t, jobs.discarded, name)
+	}
+}
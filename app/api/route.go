@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"fmt"
+	"io"
 	"strings"
 
 	"github.com/gin-gonic/gin" // for message broadcasting
@@ -22,35 +23,65 @@ type PeerOperations interface {
 
 // JobOperations defines the functionalities for job management
 type JobOperations interface {
-	PublishDeploymentRequest(ctx context.Context, request shared.DeployRequest) error
+	SendDeploymentRequest(ctx context.Context, request shared.DeployRequest) (string, error)
 	HandleDeploymentRequest(ctx context.Context)
-	HandleDeploymentResponse(ctx context.Context)
 	ListPeers() []peer.ID
+	SelectPeer(runtime string, resources shared.Resources) (peer.ID, error)
+	GetJob(id string) (shared.JobRecord, bool)
+	ListJobs(filter shared.JobFilter) ([]shared.JobRecord, int)
+	WaitForJob(ctx context.Context, id string) (shared.JobRecord, error)
+	CancelJob(id string) error
+	ReadLogs(id string, seq int) ([]shared.LogEntry, bool, <-chan struct{}, error)
+	StageInput(jobID, path string, r io.Reader) (shared.File, error)
+	ReferenceInput(jobID string, file shared.File) (shared.File, error)
+	DiscardInputs(jobID string)
+	OpenArtifact(id, name string) (io.ReadCloser, shared.File, error)
+	NodeCompute() (*pkg.AvailableCompute, error)
 }
 
-// api struct holds references to PeerOperations and JobOperations services
+// CapabilityOperations defines the functionalities for peer capability tracking
+type CapabilityOperations interface {
+	ListCapabilities() []shared.Capabilities
+}
+
+// api struct holds references to PeerOperations, JobOperations and CapabilityOperations services
 type api struct {
-	P2P PeerOperations
-	Job JobOperations
+	P2P        PeerOperations
+	Job        JobOperations
+	Capability CapabilityOperations
 }
 
 // NewApi creates a new instance of the api struct
-func NewApi(p2p PeerOperations, job JobOperations) *api {
+func NewApi(p2p PeerOperations, job JobOperations, capability CapabilityOperations) *api {
 	return &api{
-		P2P: p2p,
-		Job: job,
+		P2P:        p2p,
+		Job:        job,
+		Capability: capability,
 	}
 }
 
-// Run starts the api server and listens for incoming connections
-func (a *api) Run(port int) error {
-	
+// router returns the handler serving every api route
+func (a *api) router() *gin.Engine {
 	router := gin.Default()
 	router.Use(pkg.CorsMiddleware()) // attach cors middleware
 
 	router.GET("/health", a.handleHealthRequest)
 	router.POST("/peer", a.handleAddPeerRequest)
+	router.GET("/peers", a.handleListPeersRequest)
 	router.POST("/deploy", a.handleDeploymentRequest)
+	router.GET("/jobs", a.handleListJobsRequest)
+	router.GET("/jobs/:id", a.handleGetJobRequest)
+	router.GET("/jobs/:id/output", a.handleGetJobOutputRequest)
+	router.GET("/jobs/:id/logs", a.handleJobLogsRequest)
+	router.GET("/jobs/:id/artifacts", a.handleListArtifactsRequest)
+	router.GET("/jobs/:id/artifacts/*name", a.handleGetArtifactRequest)
+	router.DELETE("/jobs/:id", a.handleCancelJobRequest)
+	return router
+}
+
+// Run starts the api server and listens for incoming connections
+func (a *api) Run(port int) error {
+	router := a.router()
 
 	// Start listening for incoming connections with port handling logic
 	fmt.Println("Listening for deployment requests...")
@@ -2,18 +2,80 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"time"
 
 	libp2p "github.com/libp2p/go-libp2p"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 
 	"nunet/app/api"
+	"nunet/app/blob"
+	"nunet/app/capability"
+	"nunet/app/cgroup"
+	"nunet/app/executor"
 	"nunet/app/job"
 	"nunet/app/p2p"
+	"nunet/app/sandbox"
 	"nunet/pkg"
 )
 
-func Run(ctx context.Context, topicName string, port int) error {
+// blobCollectInterval is how often blobs unused for longer than the retention are removed
+const blobCollectInterval = 10 * time.Minute
+
+// jobCollectInterval is how often finished jobs past their retention are forgotten
+const jobCollectInterval = time.Minute
+
+// Config holds the settings the node is started with
+type Config struct {
+	TopicName string // pubsub topic peers use to find each other
+	Port      int    // REST API port
+	Scheduler string // strategy used to pick the peer a job runs on
+
+	AdvertiseInterval  time.Duration // how often capabilities are advertised to peers
+	MaxJobDuration     time.Duration // longest run time this node accepts for a job
+	MaxJobRetries      int           // how many other peers a job is tried on when it doesn't succeed
+	ResponseTimeout    time.Duration // how long to wait for the result of a job without a max duration
+	MaxConcurrentJobs  int           // jobs this node runs at the same time
+	MaxQueuedJobs      int           // jobs waiting for a free slot before new ones are rejected
+	MaxOutputBytes     int           // output of a job kept beyond this is dropped
+	EnvAllow           []string      // patterns of environment variables jobs may set, any if empty
+	EnvDeny            []string      // patterns of environment variables jobs may not set
+	WorkspaceDir       string        // where job workspaces are created, a directory of this node's own under the temp dir if empty
+	WorkspaceQuota     int64         // bytes a job may write to its workspace when it requests no disk
+	WorkspaceRetention time.Duration // how long a workspace is kept once its job ends
+	BlobDir            string        // where input files and artifacts are stored by cid, a directory of this node's own under the temp dir if empty
+	BlobRetention      time.Duration // how long an unused blob is kept
+	MaxInputBytes      int64         // total size of the files a job may carry
+	MaxArtifactBytes   int64         // total size of the files a job may bring back
+	JobRetention       time.Duration // how long a finished job and its logs are kept
+	MaxFinishedJobs    int           // finished jobs kept before the oldest are forgotten
+	WasmMaxMemoryBytes int64         // memory a wasm module may grow to
+	WasmMaxCalls       uint64        // function calls a wasm module may make, unlimited if 0
+	ContainerRuntime   string        // docker compatible tool containers are run with, the first one found if empty
+	Sandbox            bool          // run process jobs in a sandbox, Linux only
+	SandboxNetwork     bool          // let sandboxed jobs use the node's network
+	SandboxSeccomp     bool          // refuse sandboxed jobs system calls reaching beyond the sandbox
+	Cgroups            bool          // limit process jobs to the resources they asked for with cgroup v2, Linux only
+	CgroupParent       string        // cgroup jobs' cgroups are created in, the node's own if empty
+	PolicyFile         string        // JSON file of which programs peers may run here, anything by anyone if empty
+}
+
+func Run(ctx context.Context, config Config) error {
+	scheduler, err := job.NewScheduler(config.Scheduler)
+	if err != nil {
+		return fmt.Errorf("failed to create scheduler: %w", err)
+	}
+
+	// Keep other nodes on this host out of the directories this one sweeps and collects
+	unlock, err := lockNodeDirs(&config)
+	if err != nil {
+		return fmt.Errorf("failed to lock node directories: %w", err)
+	}
+	defer unlock()
+	fmt.Printf("Job workspaces in %s, blobs in %s\n", config.WorkspaceDir, config.BlobDir)
 
 	// Create a new libp2p host
 	node, err := libp2p.New(
@@ -34,7 +96,7 @@ func Run(ctx context.Context, topicName string, port int) error {
 	}
 
 	// Discover peers for communication
-	if err := P2P.DiscoverPeers(ctx, topicName); err != nil {
+	if err := P2P.DiscoverPeers(ctx, config.TopicName); err != nil {
 		return fmt.Errorf("failed to discover peers: %w", err)
 	}
 
@@ -44,39 +106,185 @@ func Run(ctx context.Context, topicName string, port int) error {
 		return fmt.Errorf("failed to create pubsub: %w", err)
 	}
 
-	deploymentTopic, err := pubSub.Join(topicName)
+	// The deployment topic lets peers running this program find each other;
+	// jobs themselves are sent point-to-point over the job protocol
+	deploymentTopic, err := pubSub.Join(config.TopicName)
 	if err != nil {
 		return fmt.Errorf("failed to join deployment topic: %w", err)
 	}
 
-	// Subscribe to deployment topic and handle requests concurrently
 	deploymentSub, err := deploymentTopic.Subscribe()
 	if err != nil {
 		return fmt.Errorf("failed to subscribe to deployment topic: %w", err)
 	}
 
-	responseTopic, err := pubSub.Join(topicName + "-response")
+	// Give each job a private directory, clearing out the ones a previous run left behind
+	workspaces, err := job.NewWorkspaces(config.WorkspaceDir, config.WorkspaceQuota, config.WorkspaceRetention)
 	if err != nil {
-		return fmt.Errorf("failed to join deployment response topic: %w", err)
+		return fmt.Errorf("failed to set up job workspaces: %w", err)
 	}
+	workspaces.Sweep()
 
-	// Subscribe to deployment response topic
-	responseSub, err := responseTopic.Subscribe()
+	// Keep input files and artifacts by cid and exchange them with peers
+	blobs, err := blob.NewStore(config.BlobDir)
 	if err != nil {
-		return fmt.Errorf("failed to subscribe to deployment response topic: %w", err)
+		return fmt.Errorf("failed to set up blob store: %w", err)
 	}
+	go blobs.CollectGarbage(ctx, blobCollectInterval, config.BlobRetention)
+
+	maxBlobBytes := max(config.MaxInputBytes, config.MaxArtifactBytes)
+	if config.MaxInputBytes == 0 || config.MaxArtifactBytes == 0 {
+		maxBlobBytes = 0 // either kind of file is unlimited
+	}
+	exchange := blob.NewExchange(node, blobs, maxBlobBytes)
+	exchange.Serve()
+	defer exchange.Close()
+
+	// Run jobs as processes, sandboxed if configured, wasm modules, and containers when a container runtime is installed
+	process := executor.NewProcess()
+	if config.Sandbox {
+		policy := sandbox.Policy{
+			Network: config.SandboxNetwork,
+			Seccomp: config.SandboxSeccomp,
+			Hidden:  []string{config.WorkspaceDir, config.BlobDir}, // other jobs' files
+		}
+		if err := sandbox.Check(policy); err != nil {
+			return fmt.Errorf("failed to set up job sandbox: %w", err)
+		}
+		process = executor.NewSandboxedProcess(policy)
+	}
+	if config.Cgroups {
+		if cgroups, err := cgroup.NewManager(config.CgroupParent); err == nil {
+			process.Cgroups = cgroups
+		} else {
+			fmt.Println("Job resource limits are disabled:", err)
+		}
+	}
+	runners := []executor.Executor{
+		process,
+		executor.NewWasm(config.WasmMaxMemoryBytes, config.WasmMaxCalls),
+	}
+	if runtime, err := executor.DetectCLIRuntime(config.ContainerRuntime); err == nil {
+		runners = append(runners, executor.NewContainer(runtime))
+	} else {
+		fmt.Println("Container jobs are disabled:", err)
+	}
+	executors := executor.NewSet(runners...)
 
 	jobs := job.New(
 		node,
 		deploymentTopic,
 		deploymentSub,
-		responseTopic,
-		responseSub,
+		scheduler,
+		job.NewAdmission(config.MaxJobDuration, config.WorkspaceDir),
+		job.NewPool(config.MaxConcurrentJobs, config.MaxQueuedJobs),
+		executors,
+		workspaces,
+		blobs,
+		exchange,
 	)
+	jobs.MaxRetries = config.MaxJobRetries
+	jobs.ResponseTimeout = config.ResponseTimeout
+	jobs.MaxOutputBytes = config.MaxOutputBytes
+	jobs.MaxInputBytes = config.MaxInputBytes
+	jobs.MaxArtifactBytes = config.MaxArtifactBytes
+	jobs.JobRetention = config.JobRetention
+	jobs.MaxFinishedJobs = config.MaxFinishedJobs
+	jobs.EnvPolicy = job.EnvPolicy{Allow: config.EnvAllow, Deny: config.EnvDeny}
+	if config.PolicyFile != "" {
+		policy, err := job.LoadPolicy(config.PolicyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load job policy: %w", err)
+		}
+		jobs.Policy = policy
+	} else {
+		fmt.Println("No job policy set, any peer may run any program on this node")
+	}
 	go jobs.HandleDeploymentRequest(ctx)
-	go jobs.HandleDeploymentResponse(ctx)
+	go jobs.CollectFinished(ctx, jobCollectInterval)
+
+	// Advertise this node's capabilities and keep track of the peers'
+	capabilityTopic, err := pubSub.Join(config.TopicName + "-capabilities")
+	if err != nil {
+		return fmt.Errorf("failed to join capabilities topic: %w", err)
+	}
+
+	capabilitySub, err := capabilityTopic.Subscribe()
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to capabilities topic: %w", err)
+	}
+
+	capabilities := capability.New(
+		node,
+		capabilityTopic,
+		capabilitySub,
+		config.AdvertiseInterval,
+		jobs.AvailableCompute,
+		jobs.RunningJobs,
+	)
+	capabilities.Executors = executors.Names()
+	jobs.PeerCompute = capabilities.Table.Compute
+	jobs.PeerExecutors = capabilities.Table.Executors
+	go capabilities.Advertise(ctx)
+	go capabilities.HandleAdvertisements(ctx)
 
 	// Create and run the REST API
-	API := api.NewApi(P2P, jobs)
-	return API.Run(port)
+	API := api.NewApi(P2P, jobs, capabilities)
+	return API.Run(config.Port)
+}
+
+// maxNodeDirs bounds how many nodes on a host get directories of their own by default
+const maxNodeDirs = 100
+
+// lockNodeDirs locks the workspace and blob directories for this node, so that no other
+// node on the host sweeps its workspaces or collects blobs it has pinned. Directories left
+// unset default to the first pair under the temp dir no other node is using.
+func lockNodeDirs(config *Config) (func(), error) {
+	if config.WorkspaceDir != "" && config.BlobDir != "" {
+		return lockDirs(config.WorkspaceDir, config.BlobDir)
+	}
+
+	for i := 1; i <= maxNodeDirs; i++ {
+		suffix := ""
+		if i > 1 {
+			suffix = fmt.Sprintf("-%d", i)
+		}
+		workspaceDir, blobDir := config.WorkspaceDir, config.BlobDir
+		if workspaceDir == "" {
+			workspaceDir = filepath.Join(os.TempDir(), "nunet-jobs"+suffix)
+		}
+		if blobDir == "" {
+			blobDir = filepath.Join(os.TempDir(), "nunet-blobs"+suffix)
+		}
+
+		unlock, err := lockDirs(workspaceDir, blobDir)
+		if errors.Is(err, pkg.ErrLocked) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		config.WorkspaceDir, config.BlobDir = workspaceDir, blobDir
+		return unlock, nil
+	}
+	return nil, fmt.Errorf("every default directory is in use, set WORKSPACE_DIR and BLOB_DIR")
+}
+
+// lockDirs locks each directory through a lock file next to it
+func lockDirs(dirs ...string) (func(), error) {
+	var unlocks []func()
+	unlock := func() {
+		for _, unlock := range unlocks {
+			unlock()
+		}
+	}
+	for _, dir := range dirs {
+		release, err := pkg.Lock(filepath.Clean(dir) + ".lock")
+		if err != nil {
+			unlock()
+			return nil, fmt.Errorf("%s: %w", dir, err)
+		}
+		unlocks = append(unlocks, release)
+	}
+	return unlock, nil
 }
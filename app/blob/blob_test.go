@@ -0,0 +1,192 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	libp2p "github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorePut(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	c, size, err := store.Put(strings.NewReader("hello"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, "bafkreibm6jg3ux5qumhcn2b3flc3tyu6dmlb4xa7u5bf44yegnrjhc4yeq", c.String())
+	assert.Equal(t, Sum([]byte("hello")), c)
+	assert.Equal(t, int64(5), size)
+	assert.True(t, store.Has(c))
+
+	// identical content is stored once
+	again, _, err := store.Put(strings.NewReader("hello"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, c, again)
+	entries, err := os.ReadDir(store.Root)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	file, size, err := store.Open(c)
+	require.NoError(t, err)
+	contents, err := io.ReadAll(file)
+	file.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(contents))
+	assert.Equal(t, int64(5), size)
+
+	_, _, err = store.Put(strings.NewReader("too long"), 4)
+	assert.ErrorIs(t, err, ErrTooLarge)
+	_, err = store.PutExpected(c, strings.NewReader("other"), 0)
+	assert.ErrorIs(t, err, ErrMismatch)
+	_, _, err = store.Open(Sum([]byte("missing")))
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	entries, err = os.ReadDir(store.Root)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1) // nothing left behind by failed writes
+}
+
+func TestStoreCollect(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	kept, _, err := store.Put(strings.NewReader("kept"), 0)
+	require.NoError(t, err)
+	dropped, _, err := store.Put(strings.NewReader("dropped"), 0)
+	require.NoError(t, err)
+
+	store.Pin(kept, "job-1")
+	store.Pin(kept, "job-2")
+	store.Unpin(kept, "job-1")
+	assert.True(t, store.Pinned(kept))
+
+	store.Collect(time.Hour)
+	assert.True(t, store.Has(dropped)) // recently used
+
+	store.Collect(0)
+	assert.True(t, store.Has(kept))
+	assert.False(t, store.Has(dropped))
+
+	store.Unpin(kept, "job-2")
+	store.Collect(0)
+	assert.False(t, store.Has(kept))
+}
+
+// newTestExchanges starts two connected hosts serving blobs from their own store
+func newTestExchanges(t *testing.T) (*Exchange, *Exchange) {
+	t.Helper()
+
+	var exchanges []*Exchange
+	for i := 0; i < 2; i++ {
+		h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+
+		store, err := NewStore(t.TempDir())
+		require.NoError(t, err)
+		exchange := NewExchange(h, store, 0)
+		exchange.Serve()
+		exchanges = append(exchanges, exchange)
+	}
+
+	var hosts []host.Host
+	for _, exchange := range exchanges {
+		hosts = append(hosts, exchange.Host)
+	}
+	err := hosts[0].Connect(context.Background(), peer.AddrInfo{ID: hosts[1].ID(), Addrs: hosts[1].Addrs()})
+	require.NoError(t, err)
+	return exchanges[0], exchanges[1]
+}
+
+func TestExchangeFetch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	local, remote := newTestExchanges(t)
+	c, _, err := remote.Store.Put(strings.NewReader("shared"), 0)
+	require.NoError(t, err)
+
+	// connected peers are asked when no provider is given
+	require.NoError(t, local.Fetch(ctx, c))
+	assert.True(t, local.Store.Has(c))
+
+	err = local.Fetch(ctx, Sum([]byte("nobody has this")), remote.Host.ID())
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// content that doesn't match its cid is refused
+	tampered, _, err := remote.Store.Put(strings.NewReader("original"), 0)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(remote.Store.Root, tampered.String()), []byte("tampered"), 0o600))
+	err = local.Fetch(ctx, tampered, remote.Host.ID())
+	assert.ErrorIs(t, err, ErrMismatch)
+	assert.False(t, local.Store.Has(tampered))
+
+	local.MaxBytes = 2
+	big, _, err := remote.Store.Put(strings.NewReader("larger"), 0)
+	require.NoError(t, err)
+	err = local.Fetch(ctx, big, remote.Host.ID())
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestExchangeFetchStalled(t *testing.T) {
+	local, remote := newTestExchanges(t)
+
+	// a provider that reads the request and never answers
+	stalled := make(chan struct{})
+	t.Cleanup(func() { close(stalled) })
+	remote.Host.SetStreamHandler(ProtocolID, func(s network.Stream) {
+		defer s.Reset()
+		<-stalled
+	})
+
+	c := Sum([]byte("never sent"))
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	err := local.Fetch(ctx, c, remote.Host.ID())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(started), 5*time.Second)
+
+	// cancelling stops it as well, deadline or not
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	started = time.Now()
+	err = local.Fetch(ctx, c, remote.Host.ID())
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(started), 5*time.Second)
+}
+
+func TestExchangeRequestLimits(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	timeout := requestReadTimeout
+	requestReadTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { requestReadTimeout = timeout })
+
+	local, remote := newTestExchanges(t)
+
+	// a peer that opens a stream and sends nothing is dropped
+	s, err := local.Host.NewStream(ctx, remote.Host.ID(), ProtocolID)
+	require.NoError(t, err)
+	started := time.Now()
+	_, err = io.ReadAll(s)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(started), 5*time.Second)
+
+	// so is one sending a request without end
+	s, err = local.Host.NewStream(ctx, remote.Host.ID(), ProtocolID)
+	require.NoError(t, err)
+	go s.Write([]byte(`{"cid":"` + strings.Repeat("a", 2*maxRequestBytes)))
+	_, err = io.ReadAll(s)
+	assert.Error(t, err)
+}
@@ -0,0 +1,173 @@
+package blob
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// ProtocolID is the libp2p protocol peers fetch blobs from each other over
+const ProtocolID = protocol.ID("/nunet/blob/1.0.0")
+
+// maxRequestBytes bounds the request read from a peer, far above what a cid takes
+const maxRequestBytes = 4 << 10
+
+// requestReadTimeout bounds how long a peer opening a blob stream has to send its request
+var requestReadTimeout = 30 * time.Second
+
+// request asks a peer for a blob
+type request struct {
+	CID string `json:"cid"`
+}
+
+// header precedes the contents of a blob on the stream
+type header struct {
+	Size int64  `json:"size"`
+	Err  string `json:"err,omitempty"`
+}
+
+// Exchange serves the blobs of the local store to peers and fetches missing ones from them.
+// Any peer knowing a blob's CID may fetch it.
+type Exchange struct {
+	Host     host.Host
+	Store    *Store
+	MaxBytes int64 // largest blob fetched from a peer, unlimited if 0
+}
+
+// NewExchange creates an exchange for the store
+func NewExchange(h host.Host, store *Store, maxBytes int64) *Exchange {
+	return &Exchange{
+		Host:     h,
+		Store:    store,
+		MaxBytes: maxBytes,
+	}
+}
+
+// Serve registers the blob protocol on the host
+func (e *Exchange) Serve() {
+	e.Host.SetStreamHandler(ProtocolID, e.handleStream)
+}
+
+// Close unregisters the blob protocol
+func (e *Exchange) Close() {
+	e.Host.RemoveStreamHandler(ProtocolID)
+}
+
+func (e *Exchange) handleStream(s network.Stream) {
+	defer s.Close()
+
+	var req request
+	s.SetReadDeadline(time.Now().Add(requestReadTimeout))
+	if err := json.NewDecoder(io.LimitReader(s, maxRequestBytes)).Decode(&req); err != nil {
+		fmt.Println("Error reading blob request:", err)
+		s.Reset()
+		return
+	}
+	s.SetReadDeadline(time.Time{})
+
+	c, err := cid.Decode(req.CID)
+	if err != nil {
+		json.NewEncoder(s).Encode(header{Err: fmt.Sprintf("invalid cid: %s", err)})
+		return
+	}
+	file, size, err := e.Store.Open(c)
+	if err != nil {
+		json.NewEncoder(s).Encode(header{Err: err.Error()})
+		return
+	}
+	defer file.Close()
+
+	if err := json.NewEncoder(s).Encode(header{Size: size}); err != nil {
+		fmt.Println("Error sending blob:", err)
+		s.Reset()
+		return
+	}
+	if _, err := io.CopyN(s, file, size); err != nil {
+		fmt.Println("Error sending blob:", err)
+		s.Reset()
+	}
+}
+
+// Fetch makes sure the store holds the blob, downloading it if needed. The providers are
+// asked first, then every other connected peer, until one of them has it.
+func (e *Exchange) Fetch(ctx context.Context, c cid.Cid, providers ...peer.ID) error {
+	if e.Store.Has(c) {
+		return nil
+	}
+
+	tried := map[peer.ID]bool{e.Host.ID(): true}
+	var errs []error
+	for _, candidates := range [][]peer.ID{providers, e.Host.Network().Peers()} {
+		for _, p := range candidates {
+			if tried[p] {
+				continue
+			}
+			tried[p] = true
+
+			err := e.fetchFrom(ctx, p, c)
+			if err == nil {
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", p, err))
+		}
+	}
+	return fmt.Errorf("%w: no peer could provide %s: %w", ErrNotFound, c, errors.Join(errs...))
+}
+
+func (e *Exchange) fetchFrom(ctx context.Context, p peer.ID, c cid.Cid) error {
+	s, err := e.Host.NewStream(ctx, p, ProtocolID)
+	if err != nil {
+		return fmt.Errorf("error opening stream: %w", err)
+	}
+	defer s.Close()
+
+	// a stalled provider must not outlive ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { s.Reset() })
+	defer stop()
+
+	if err := json.NewEncoder(s).Encode(request{CID: c.String()}); err != nil {
+		s.Reset()
+		return fmt.Errorf("error requesting blob: %w", err)
+	}
+
+	reader := bufio.NewReader(s)
+	line, err := reader.ReadSlice('\n') // fails on headers longer than the buffer
+	if err != nil {
+		s.Reset()
+		return fmt.Errorf("error reading blob header: %w", err)
+	}
+	var h header
+	if err := json.Unmarshal(line, &h); err != nil {
+		s.Reset()
+		return fmt.Errorf("error reading blob header: %w", err)
+	}
+	if h.Err != "" {
+		return errors.New(h.Err)
+	}
+	if e.MaxBytes > 0 && h.Size > e.MaxBytes {
+		s.Reset()
+		return fmt.Errorf("%w: %d bytes", ErrTooLarge, h.Size)
+	}
+
+	if _, err := e.Store.PutExpected(c, io.LimitReader(reader, h.Size), e.MaxBytes); err != nil {
+		s.Reset()
+		return err
+	}
+	return nil
+}
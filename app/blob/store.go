@@ -0,0 +1,244 @@
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+var (
+	ErrNotFound = errors.New("blob not found")
+	ErrMismatch = errors.New("blob does not match its cid")
+	ErrTooLarge = errors.New("blob is too large")
+)
+
+// Store keeps blobs on disk under their CID, so identical content is only stored once.
+// Blobs that aren't pinned are removed once they haven't been used for a while.
+type Store struct {
+	Root string
+
+	mu   sync.Mutex
+	pins map[cid.Cid]map[string]bool // cid -> owners keeping the blob
+}
+
+// NewStore creates a store in root, keeping whatever blobs are already there
+func NewStore(root string) (*Store, error) {
+	if err := os.MkdirAll(root, 0o700); err != nil {
+		return nil, fmt.Errorf("error creating blob store: %w", err)
+	}
+	return &Store{
+		Root: root,
+		pins: make(map[cid.Cid]map[string]bool),
+	}, nil
+}
+
+// Sum returns the CID of the content: CIDv1, raw codec, sha2-256
+func Sum(data []byte) cid.Cid {
+	digest := sha256.Sum256(data)
+	return newCid(digest[:])
+}
+
+func newCid(digest []byte) cid.Cid {
+	mh, _ := multihash.Encode(digest, multihash.SHA2_256) // can't fail for a known code
+	return cid.NewCidV1(cid.Raw, mh)
+}
+
+// Put stores the contents of r, at most maxBytes of it if maxBytes is positive
+func (s *Store) Put(r io.Reader, maxBytes int64) (cid.Cid, int64, error) {
+	tmp, c, size, err := s.write(r, maxBytes)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	if err := s.commit(tmp, c); err != nil {
+		return cid.Undef, 0, err
+	}
+	return c, size, nil
+}
+
+// PutExpected stores the contents of r if they hash to the expected CID
+func (s *Store) PutExpected(expected cid.Cid, r io.Reader, maxBytes int64) (int64, error) {
+	tmp, c, size, err := s.write(r, maxBytes)
+	if err != nil {
+		return 0, err
+	}
+	if !c.Equals(expected) {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("%w: got %s, expected %s", ErrMismatch, c, expected)
+	}
+	if err := s.commit(tmp, c); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// write copies r into a temporary file of the store, hashing it along the way
+func (s *Store) write(r io.Reader, maxBytes int64) (string, cid.Cid, int64, error) {
+	tmp, err := os.CreateTemp(s.Root, ".tmp-*")
+	if err != nil {
+		return "", cid.Undef, 0, fmt.Errorf("error storing blob: %w", err)
+	}
+	defer tmp.Close()
+
+	if maxBytes > 0 {
+		r = io.LimitReader(r, maxBytes+1)
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err == nil && maxBytes > 0 && size > maxBytes {
+		err = fmt.Errorf("%w: more than %d bytes", ErrTooLarge, maxBytes)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", cid.Undef, 0, err
+	}
+	return tmp.Name(), newCid(hash.Sum(nil)), size, nil
+}
+
+// commit moves a written blob into place, or drops it if the store already has the content
+func (s *Store) commit(tmp string, c cid.Cid) error {
+	path := s.path(c)
+	if _, err := os.Stat(path); err == nil {
+		os.Remove(tmp)
+		s.touch(c)
+		return nil
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error storing blob: %w", err)
+	}
+	return nil
+}
+
+// Has reports whether the store holds the blob
+func (s *Store) Has(c cid.Cid) bool {
+	_, err := os.Stat(s.path(c))
+	return err == nil
+}
+
+// Open returns the contents of a blob along with its size
+func (s *Store) Open(c cid.Cid) (*os.File, int64, error) {
+	file, err := os.Open(s.path(c))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, fmt.Errorf("%w: %s", ErrNotFound, c)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("error opening blob: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("error opening blob: %w", err)
+	}
+	s.touch(c)
+	return file, info.Size(), nil
+}
+
+// CopyTo writes a blob to a new file at path
+func (s *Store) CopyTo(c cid.Cid, path string) error {
+	src, _, err := s.Open(c)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("error creating file: %w", err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("error copying blob: %w", err)
+	}
+	return dst.Close()
+}
+
+// Pin keeps the blob from being collected until the owner unpins it
+func (s *Store) Pin(c cid.Cid, owner string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pins[c] == nil {
+		s.pins[c] = make(map[string]bool)
+	}
+	s.pins[c][owner] = true
+}
+
+// Unpin releases the owner's hold on the blob; it is collected once unused for long enough
+func (s *Store) Unpin(c cid.Cid, owner string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.pins[c], owner)
+	if len(s.pins[c]) == 0 {
+		delete(s.pins, c)
+	}
+	s.touch(c)
+}
+
+// Pinned reports whether any owner keeps the blob
+func (s *Store) Pinned(c cid.Cid) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pins[c]) > 0
+}
+
+// touch marks the blob as used now
+func (s *Store) touch(c cid.Cid) {
+	now := time.Now()
+	os.Chtimes(s.path(c), now, now)
+}
+
+// Collect removes the blobs that aren't pinned and haven't been used for maxAge
+func (s *Store) Collect(maxAge time.Duration) {
+	entries, err := os.ReadDir(s.Root)
+	if err != nil {
+		fmt.Println("Error listing blobs:", err)
+		return
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+		c, err := cid.Decode(entry.Name())
+		if err != nil {
+			os.Remove(filepath.Join(s.Root, entry.Name())) // leftover temporary file
+			continue
+		}
+		if s.Pinned(c) {
+			continue
+		}
+		if err := os.Remove(s.path(c)); err != nil {
+			fmt.Println("Error removing blob:", err)
+		}
+	}
+}
+
+// CollectGarbage runs Collect every interval until ctx is done
+func (s *Store) CollectGarbage(ctx context.Context, interval, maxAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Collect(maxAge)
+		}
+	}
+}
+
+func (s *Store) path(c cid.Cid) string {
+	return filepath.Join(s.Root, c.String())
+}
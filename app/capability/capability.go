@@ -0,0 +1,215 @@
+package capability
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"nunet/app/shared"
+	"nunet/pkg"
+)
+
+// knownRuntimes are the interpreters and tools looked up on the node's PATH
+var knownRuntimes = []string{
+	"sh", "bash", "python3", "python", "node", "java", "ruby", "perl", "go", "docker", "podman",
+}
+
+// Capability periodically advertises this node's capabilities and tracks the ones advertised by peers
+type Capability struct {
+	Host        host.Host
+	Topic       *pubsub.Topic
+	Sub         *pubsub.Subscription
+	Table       *Table
+	Interval    time.Duration
+	Compute     func() (*pkg.AvailableCompute, error) // compute this node can still offer
+	RunningJobs func() int                            // number of jobs this node is executing
+	Executors   []string                              // runtimes jobs sent to this node can run in
+
+	runtimes []string
+}
+
+// New creates a new Capability instance. Peers are forgotten after missing three advertisements.
+func New(
+	h host.Host,
+	topic *pubsub.Topic,
+	sub *pubsub.Subscription,
+	interval time.Duration,
+	compute func() (*pkg.AvailableCompute, error),
+	runningJobs func() int,
+) *Capability {
+	return &Capability{
+		Host:        h,
+		Topic:       topic,
+		Sub:         sub,
+		Table:       NewTable(3 * interval),
+		Interval:    interval,
+		Compute:     compute,
+		RunningJobs: runningJobs,
+		runtimes:    detectRuntimes(),
+	}
+}
+
+// detectRuntimes returns the known runtimes installed on this node
+func detectRuntimes() []string {
+	var found []string
+	for _, name := range knownRuntimes {
+		if _, err := exec.LookPath(name); err == nil {
+			found = append(found, name)
+		}
+	}
+	return found
+}
+
+// Snapshot returns the current capabilities of this node
+func (c *Capability) Snapshot() (shared.Capabilities, error) {
+	compute, err := c.Compute()
+	if err != nil {
+		return shared.Capabilities{}, fmt.Errorf("error getting compute availability: %w", err)
+	}
+
+	running := 0
+	if c.RunningJobs != nil {
+		running = c.RunningJobs()
+	}
+
+	return shared.Capabilities{
+		PeerID:      c.Host.ID().String(),
+		Compute:     *compute,
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+		Runtimes:    c.runtimes,
+		Executors:   c.Executors,
+		RunningJobs: running,
+		Timestamp:   time.Now().UTC(),
+	}, nil
+}
+
+// Advertise publishes a signed snapshot of this node's capabilities every interval
+func (c *Capability) Advertise(ctx context.Context) {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.publish(ctx); err != nil {
+			fmt.Println("Error advertising capabilities:", err)
+		}
+		c.Table.Prune()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Capability) publish(ctx context.Context) error {
+	capabilities, err := c.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	signed, err := Sign(c.Host.Peerstore().PrivKey(c.Host.ID()), capabilities)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(signed)
+	if err != nil {
+		return fmt.Errorf("error marshalling capabilities: %w", err)
+	}
+
+	if err := c.Topic.Publish(ctx, data); err != nil {
+		return fmt.Errorf("error publishing capabilities: %w", err)
+	}
+	return nil
+}
+
+// HandleAdvertisements records the capabilities advertised by peers
+func (c *Capability) HandleAdvertisements(ctx context.Context) {
+	for {
+		msg, err := c.Sub.Next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Println("Error reading message:", err)
+			continue
+		}
+		if c.Host.ID() == msg.GetFrom() { // Ignore messages from self
+			continue
+		}
+
+		var signed shared.SignedCapabilities
+		if err := json.Unmarshal(msg.GetData(), &signed); err != nil {
+			fmt.Println("Error unmarshalling capabilities:", err)
+			continue
+		}
+
+		capabilities, err := Verify(msg.GetFrom(), signed)
+		if err != nil {
+			fmt.Println("Discarding capabilities:", err)
+			continue
+		}
+
+		c.Table.Update(msg.GetFrom(), capabilities)
+	}
+}
+
+// ListCapabilities returns the capabilities of the peers heard from recently
+func (c *Capability) ListCapabilities() []shared.Capabilities {
+	return c.Table.List()
+}
+
+// Sign encodes the capabilities and signs them with the given key
+func Sign(key crypto.PrivKey, capabilities shared.Capabilities) (shared.SignedCapabilities, error) {
+	if key == nil {
+		return shared.SignedCapabilities{}, fmt.Errorf("no private key to sign capabilities with")
+	}
+
+	payload, err := json.Marshal(capabilities)
+	if err != nil {
+		return shared.SignedCapabilities{}, fmt.Errorf("error marshalling capabilities: %w", err)
+	}
+
+	signature, err := key.Sign(payload)
+	if err != nil {
+		return shared.SignedCapabilities{}, fmt.Errorf("error signing capabilities: %w", err)
+	}
+
+	return shared.SignedCapabilities{
+		Payload:   payload,
+		Signature: signature,
+	}, nil
+}
+
+// Verify checks that the capabilities were signed by, and describe, the given peer
+func Verify(from peer.ID, signed shared.SignedCapabilities) (shared.Capabilities, error) {
+	key, err := from.ExtractPublicKey()
+	if err != nil {
+		return shared.Capabilities{}, fmt.Errorf("error extracting public key of %s: %w", from, err)
+	}
+
+	ok, err := key.Verify(signed.Payload, signed.Signature)
+	if err != nil || !ok {
+		return shared.Capabilities{}, fmt.Errorf("invalid signature from %s", from)
+	}
+
+	var capabilities shared.Capabilities
+	if err := json.Unmarshal(signed.Payload, &capabilities); err != nil {
+		return shared.Capabilities{}, fmt.Errorf("error unmarshalling capabilities: %w", err)
+	}
+
+	if capabilities.PeerID != from.String() {
+		return shared.Capabilities{}, fmt.Errorf("capabilities of %s advertised by %s", capabilities.PeerID, from)
+	}
+	return capabilities, nil
+}
@@ -0,0 +1,70 @@
+package capability
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nunet/app/shared"
+	"nunet/pkg"
+)
+
+func newTestIdentity(t *testing.T) (crypto.PrivKey, peer.ID) {
+	t.Helper()
+	key, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+	return key, id
+}
+
+func TestSignAndVerify(t *testing.T) {
+	key, id := newTestIdentity(t)
+	_, otherID := newTestIdentity(t)
+
+	capabilities := shared.Capabilities{
+		PeerID:    id.String(),
+		Compute:   pkg.AvailableCompute{FreeCPUCores: 4, FreeRAM: 8},
+		OS:        "linux",
+		Timestamp: time.Now().UTC(),
+	}
+	signed, err := Sign(key, capabilities)
+	require.NoError(t, err)
+
+	verified, err := Verify(id, signed)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, verified.Compute.FreeCPUCores)
+
+	// relayed by someone else
+	_, err = Verify(otherID, signed)
+	assert.Error(t, err)
+
+	// tampered with
+	signed.Payload[len(signed.Payload)-2] ^= 1
+	_, err = Verify(id, signed)
+	assert.Error(t, err)
+}
+
+func TestTableExpiry(t *testing.T) {
+	table := NewTable(20 * time.Millisecond)
+	now := time.Now()
+
+	table.Update("peer-a", shared.Capabilities{PeerID: "peer-a", Timestamp: now, Compute: pkg.AvailableCompute{FreeRAM: 2}})
+	table.Update("peer-a", shared.Capabilities{PeerID: "peer-a", Timestamp: now.Add(-time.Second)}) // stale
+	table.Update("peer-b", shared.Capabilities{PeerID: "peer-b", Timestamp: now})
+
+	assert.Len(t, table.List(), 2)
+	assert.Equal(t, 2.0, table.Compute("peer-a").FreeRAM)
+	assert.Nil(t, table.Compute("peer-c"))
+
+	time.Sleep(30 * time.Millisecond)
+	assert.Empty(t, table.List())
+	assert.Nil(t, table.Compute("peer-a"))
+
+	table.Prune()
+	assert.Empty(t, table.entries)
+}
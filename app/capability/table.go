@@ -0,0 +1,107 @@
+package capability
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"nunet/app/shared"
+	"nunet/pkg"
+)
+
+type entry struct {
+	capabilities shared.Capabilities
+	expiresAt    time.Time
+}
+
+// Table holds the latest capabilities advertised by each peer until they expire
+type Table struct {
+	mu      sync.RWMutex
+	ttl     time.Duration
+	entries map[peer.ID]entry
+}
+
+// NewTable creates a table whose entries expire ttl after they were last refreshed
+func NewTable(ttl time.Duration) *Table {
+	return &Table{
+		ttl:     ttl,
+		entries: make(map[peer.ID]entry),
+	}
+}
+
+// Update stores the capabilities advertised by a peer, replacing older snapshots
+func (t *Table) Update(id peer.ID, capabilities shared.Capabilities) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if current, ok := t.entries[id]; ok && current.capabilities.Timestamp.After(capabilities.Timestamp) {
+		return // out of order delivery
+	}
+	t.entries[id] = entry{
+		capabilities: capabilities,
+		expiresAt:    time.Now().Add(t.ttl),
+	}
+}
+
+// Get returns the capabilities of a peer if they haven't expired
+func (t *Table) Get(id peer.ID) (shared.Capabilities, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	e, ok := t.entries[id]
+	if !ok || time.Now().After(e.expiresAt) {
+		return shared.Capabilities{}, false
+	}
+	return e.capabilities, true
+}
+
+// Compute returns the resources last advertised by a peer, or nil if unknown
+func (t *Table) Compute(id peer.ID) *pkg.AvailableCompute {
+	capabilities, ok := t.Get(id)
+	if !ok {
+		return nil
+	}
+	return &capabilities.Compute
+}
+
+// Executors returns the runtimes last advertised by a peer, or nil if unknown
+func (t *Table) Executors(id peer.ID) []string {
+	capabilities, ok := t.Get(id)
+	if !ok {
+		return nil
+	}
+	return capabilities.Executors
+}
+
+// List returns the capabilities of every peer that hasn't expired, ordered by peer id
+func (t *Table) List() []shared.Capabilities {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	now := time.Now()
+	results := make([]shared.Capabilities, 0, len(t.entries))
+	for _, e := range t.entries {
+		if now.Before(e.expiresAt) {
+			results = append(results, e.capabilities)
+		}
+	}
+	sort.Slice(results, func(i, k int) bool {
+		return results[i].PeerID < results[k].PeerID
+	})
+	return results
+}
+
+// Prune drops expired entries
+func (t *Table) Prune() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for id, e := range t.entries {
+		if now.After(e.expiresAt) {
+			delete(t.entries, id)
+		}
+	}
+}
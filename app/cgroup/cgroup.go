@@ -0,0 +1,23 @@
+// Package cgroup confines jobs to the cpu, memory and processes they asked for using cgroup v2,
+// and reads back what they used.
+package cgroup
+
+import "errors"
+
+// ErrUnsupported is returned where cgroup v2 isn't available
+var ErrUnsupported = errors.New("cgroup v2 is not available on this node")
+
+// Limits are what the processes of a cgroup may use together; zero values are unlimited
+type Limits struct {
+	CPUs        float64 // cores
+	MemoryBytes int64
+	Pids        int64 // processes and threads
+}
+
+// Stats are what the processes of a cgroup used
+type Stats struct {
+	UserCPUMs   int64
+	SystemCPUMs int64
+	MemoryPeak  int64 // bytes, 0 where the kernel doesn't keep track
+	OOMKills    int64 // processes killed for going over the memory limit
+}
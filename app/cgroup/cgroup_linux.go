@@ -0,0 +1,244 @@
+//go:build linux
+
+package cgroup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// mountPoint is where the cgroup v2 hierarchy is mounted
+const mountPoint = "/sys/fs/cgroup"
+
+// controllers are the ones jobs are limited with
+var controllers = []string{"cpu", "memory", "pids"}
+
+const (
+	cpuPeriod     = 100000 // microseconds cpu quotas are given per
+	groupPrefix   = "job-"
+	nodeGroup     = "node" // where the node moves itself when using its own cgroup
+	removeRetries = 100    // tries at removing a cgroup whose processes are still exiting
+)
+
+// Manager creates a cgroup for each job under a parent cgroup delegated to the node
+type Manager struct {
+	Parent string // directory of the parent cgroup
+}
+
+// NewManager returns a manager creating cgroups under parent, a directory in the cgroup v2
+// hierarchy. If parent is empty the node's own cgroup is used; the node then moves itself into
+// a child of it, as a cgroup with processes of its own can't hand controllers down.
+func NewManager(parent string) (*Manager, error) {
+	if _, err := os.Stat(filepath.Join(mountPoint, "cgroup.controllers")); err != nil {
+		return nil, ErrUnsupported
+	}
+
+	if parent == "" {
+		own, err := ownGroup()
+		if err != nil {
+			return nil, err
+		}
+		parent = own
+		if err := os.MkdirAll(filepath.Join(parent, nodeGroup), 0o755); err != nil {
+			return nil, fmt.Errorf("error creating cgroup for the node: %w", err)
+		}
+		if err := write(filepath.Join(parent, nodeGroup), "cgroup.procs", strconv.Itoa(os.Getpid())); err != nil {
+			return nil, fmt.Errorf("error moving the node to its cgroup: %w", err)
+		}
+	}
+
+	available, err := os.ReadFile(filepath.Join(parent, "cgroup.controllers"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading cgroup %s: %w", parent, err)
+	}
+	var enable []string
+	for _, controller := range controllers {
+		if !slices.Contains(strings.Fields(string(available)), controller) {
+			return nil, fmt.Errorf("the %s controller is not delegated to cgroup %s", controller, parent)
+		}
+		enable = append(enable, "+"+controller)
+	}
+	if err := write(parent, "cgroup.subtree_control", strings.Join(enable, " ")); err != nil {
+		return nil, fmt.Errorf("error enabling controllers in cgroup %s: %w", parent, err)
+	}
+
+	m := &Manager{Parent: parent}
+	m.removeStale()
+	return m, nil
+}
+
+// ownGroup returns the directory of the node's cgroup
+func ownGroup() (string, error) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return filepath.Join(mountPoint, path), nil
+		}
+	}
+	return "", ErrUnsupported
+}
+
+// removeStale removes the cgroups of jobs left behind by an earlier run of the node
+func (m *Manager) removeStale() {
+	entries, _ := os.ReadDir(m.Parent)
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), groupPrefix) {
+			os.Remove(filepath.Join(m.Parent, entry.Name()))
+		}
+	}
+}
+
+// Create makes the cgroup of a job, with the limits set
+func (m *Manager) Create(name string, limits Limits) (*Group, error) {
+	dir := filepath.Join(m.Parent, groupPrefix+name)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating cgroup: %w", err)
+	}
+	group := &Group{Dir: dir}
+
+	settings := map[string]string{}
+	if limits.CPUs > 0 {
+		settings["cpu.max"] = fmt.Sprintf("%d %d", int64(limits.CPUs*cpuPeriod), cpuPeriod)
+	}
+	if limits.MemoryBytes > 0 {
+		settings["memory.max"] = strconv.FormatInt(limits.MemoryBytes, 10)
+		// Swapping would let a job go over its memory without ever being stopped
+		if _, err := os.Stat(filepath.Join(dir, "memory.swap.max")); err == nil {
+			settings["memory.swap.max"] = "0"
+		}
+	}
+	if limits.Pids > 0 {
+		settings["pids.max"] = strconv.FormatInt(limits.Pids, 10)
+	}
+	for file, value := range settings {
+		if err := write(dir, file, value); err != nil {
+			group.Remove()
+			return nil, fmt.Errorf("error setting %s: %w", file, err)
+		}
+	}
+
+	file, err := os.Open(dir)
+	if err != nil {
+		group.Remove()
+		return nil, err
+	}
+	group.file = file
+	return group, nil
+}
+
+// Group is the cgroup of a job
+type Group struct {
+	Dir string
+
+	file *os.File
+}
+
+// Setup makes the command start in the cgroup, so none of its processes escape the limits
+func (g *Group) Setup(cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(g.file.Fd())
+	return nil
+}
+
+// Stats reads what the processes of the cgroup used
+func (g *Group) Stats() (Stats, error) {
+	var stats Stats
+	cpu, err := readKeyed(g.Dir, "cpu.stat")
+	if err != nil {
+		return stats, err
+	}
+	stats.UserCPUMs = cpu["user_usec"] / 1000
+	stats.SystemCPUMs = cpu["system_usec"] / 1000
+
+	events, err := readKeyed(g.Dir, "memory.events")
+	if err != nil {
+		return stats, err
+	}
+	stats.OOMKills = events["oom_kill"]
+
+	// memory.peak only exists since Linux 5.19
+	if data, err := os.ReadFile(filepath.Join(g.Dir, "memory.peak")); err == nil {
+		stats.MemoryPeak, _ = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	}
+	return stats, nil
+}
+
+// Kill kills every process in the cgroup
+func (g *Group) Kill() error {
+	err := write(g.Dir, "cgroup.kill", "1")
+	if !errors.Is(err, os.ErrNotExist) && !errors.Is(err, os.ErrPermission) {
+		return err
+	}
+
+	// cgroup.kill only exists since Linux 5.14
+	data, err := os.ReadFile(filepath.Join(g.Dir, "cgroup.procs"))
+	if err != nil {
+		return err
+	}
+	for _, field := range strings.Fields(string(data)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+	return nil
+}
+
+// Remove deletes the cgroup, waiting for its processes to finish exiting
+func (g *Group) Remove() error {
+	if g.file != nil {
+		g.file.Close()
+	}
+	var err error
+	for i := 0; i < removeRetries; i++ {
+		if err = os.Remove(g.Dir); err == nil || errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if !errors.Is(err, syscall.EBUSY) {
+			return err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return err
+}
+
+// readKeyed reads a cgroup file of "key value" lines
+func readKeyed(dir, file string) (map[string]int64, error) {
+	f, err := os.Open(filepath.Join(dir, file))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]int64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil {
+			values[key] = n
+		}
+	}
+	return values, scanner.Err()
+}
+
+// write sets a cgroup file
+func write(dir, file, value string) error {
+	return os.WriteFile(filepath.Join(dir, file), []byte(value), 0o644)
+}
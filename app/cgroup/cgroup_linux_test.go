@@ -0,0 +1,94 @@
+//go:build linux
+
+package cgroup
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nunet/pkg"
+)
+
+// readFile returns the contents of a file of the cgroup
+func readFile(t *testing.T, group *Group, file string) string {
+	data, err := os.ReadFile(filepath.Join(group.Dir, file))
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestCreate(t *testing.T) {
+	// Outside the cgroup hierarchy the files are plain, which is enough to check what's written
+	m := &Manager{Parent: t.TempDir()}
+	group, err := m.Create("job-1", Limits{CPUs: 1.5, MemoryBytes: 1 << 20, Pids: 10})
+	require.NoError(t, err)
+	defer group.file.Close()
+
+	assert.Equal(t, filepath.Join(m.Parent, "job-job-1"), group.Dir)
+	assert.Equal(t, "150000 100000", readFile(t, group, "cpu.max"))
+	assert.Equal(t, "1048576", readFile(t, group, "memory.max"))
+	assert.Equal(t, "10", readFile(t, group, "pids.max"))
+	assert.NoFileExists(t, filepath.Join(group.Dir, "memory.swap.max"))
+
+	_, err = m.Create("job-1", Limits{})
+	assert.Error(t, err)
+}
+
+func TestStats(t *testing.T) {
+	group := &Group{Dir: t.TempDir()}
+	require.NoError(t, write(group.Dir, "cpu.stat", "usage_usec 3500\nuser_usec 2000\nsystem_usec 1500\n"))
+	require.NoError(t, write(group.Dir, "memory.events", "low 0\nhigh 0\nmax 4\noom 1\noom_kill 1\n"))
+
+	stats, err := group.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, Stats{UserCPUMs: 2, SystemCPUMs: 1, OOMKills: 1}, stats)
+
+	require.NoError(t, write(group.Dir, "memory.peak", "4096\n"))
+	stats, err = group.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, int64(4096), stats.MemoryPeak)
+}
+
+// TestGroup runs a process in a real cgroup under NUNET_TEST_CGROUP, a cgroup v2 directory
+// delegated to the user running the tests with no processes of its own
+func TestGroup(t *testing.T) {
+	parent := os.Getenv("NUNET_TEST_CGROUP")
+	if parent == "" {
+		t.Skip("NUNET_TEST_CGROUP is not set")
+	}
+	m, err := NewManager(parent)
+	require.NoError(t, err)
+
+	group, err := m.Create("test", Limits{CPUs: 1, MemoryBytes: 32 << 20, Pids: 16})
+	require.NoError(t, err)
+	result, err := pkg.RunCmd(context.Background(), pkg.Command{
+		Name:  "sh",
+		Args:  []string{"-c", "cat /proc/self/cgroup"},
+		Setup: group.Setup,
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Lines, 1)
+	assert.Contains(t, result.Lines[0].Text, "/job-test")
+
+	_, err = group.Stats()
+	require.NoError(t, err)
+	require.NoError(t, group.Kill())
+	require.NoError(t, group.Remove())
+	assert.NoDirExists(t, group.Dir)
+
+	// Going over the memory limit gets the process killed
+	group, err = m.Create("oom", Limits{MemoryBytes: 16 << 20})
+	require.NoError(t, err)
+	defer group.Remove()
+	cmd := exec.Command("sh", "-c", "x=a; while true; do x=$x$x; done")
+	require.NoError(t, group.Setup(cmd))
+	assert.Error(t, cmd.Run())
+	stats, err := group.Stats()
+	require.NoError(t, err)
+	assert.Positive(t, stats.OOMKills)
+}
@@ -0,0 +1,45 @@
+//go:build !linux
+
+package cgroup
+
+import "os/exec"
+
+// Manager creates a cgroup for each job, on Linux only
+type Manager struct {
+	Parent string
+}
+
+// NewManager returns ErrUnsupported
+func NewManager(parent string) (*Manager, error) {
+	return nil, ErrUnsupported
+}
+
+// Create returns ErrUnsupported
+func (m *Manager) Create(name string, limits Limits) (*Group, error) {
+	return nil, ErrUnsupported
+}
+
+// Group is the cgroup of a job, on Linux only
+type Group struct {
+	Dir string
+}
+
+// Setup returns ErrUnsupported
+func (g *Group) Setup(cmd *exec.Cmd) error {
+	return ErrUnsupported
+}
+
+// Stats returns ErrUnsupported
+func (g *Group) Stats() (Stats, error) {
+	return Stats{}, ErrUnsupported
+}
+
+// Kill returns ErrUnsupported
+func (g *Group) Kill() error {
+	return ErrUnsupported
+}
+
+// Remove returns ErrUnsupported
+func (g *Group) Remove() error {
+	return ErrUnsupported
+}
@@ -0,0 +1,241 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"nunet/pkg"
+)
+
+// RuntimeContainer runs jobs in OCI containers
+const RuntimeContainer = "container"
+
+// ErrOutOfMemory is returned when a job is killed for using more memory than it may
+var ErrOutOfMemory = errors.New("job ran out of memory")
+
+// ContainerWorkDir is where the job's working directory is mounted inside its container
+const ContainerWorkDir = "/workspace"
+
+// imageReference matches the image references a job may name: a repository with an optional
+// registry, tag and digest. A reference can never start with a dash, so it's never taken as an option.
+var imageReference = regexp.MustCompile(`^[a-z0-9]+([._:/-][a-zA-Z0-9_.-]+)*(@sha256:[a-f0-9]{64})?$`)
+
+// ContainerSpec describes a container to run
+type ContainerSpec struct {
+	Name        string    // unique on the node
+	Image       string    // reference of the image to run
+	Command     []string  // overrides the image's command if set
+	Env         []string  // KEY=value pairs
+	Mount       string    // host directory mounted at ContainerWorkDir, the container's working directory
+	CPUs        int       // cores the container may use, unlimited if 0
+	MemoryBytes int64     // memory the container may use, unlimited if 0
+	Stdin       io.Reader // optional
+	Stdout      io.Writer
+	Stderr      io.Writer
+}
+
+// ContainerState is how a container ended
+type ContainerState struct {
+	ExitCode   int
+	OOMKilled  bool
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// ContainerRuntime is the container engine the Container executor drives
+type ContainerRuntime interface {
+	// Pull makes sure the image is available, passing progress messages to the callback
+	Pull(ctx context.Context, image string, progress func(status string)) error
+	// Run creates and starts a container, returning once it's started
+	Run(ctx context.Context, spec ContainerSpec) error
+	// Wait blocks until the container exits
+	Wait(ctx context.Context, name string) (ContainerState, error)
+	// Kill stops the container
+	Kill(ctx context.Context, name string) error
+	// Remove deletes the container
+	Remove(ctx context.Context, name string) error
+}
+
+// Container runs jobs whose program is an image reference; the job's arguments, if any,
+// override the image's command. The job's working directory is mounted in the container.
+type Container struct {
+	Runtime ContainerRuntime
+}
+
+// NewContainer returns the container executor driving the runtime
+func NewContainer(runtime ContainerRuntime) *Container {
+	return &Container{Runtime: runtime}
+}
+
+// Name returns RuntimeContainer
+func (c *Container) Name() string {
+	return RuntimeContainer
+}
+
+// Start pulls the job's image if needed and starts its container
+func (c *Container) Start(ctx context.Context, spec Spec) (Execution, error) {
+	if spec.Name == "" {
+		return nil, fmt.Errorf("an image is required to run a container")
+	}
+	if !imageReference.MatchString(spec.Name) {
+		return nil, fmt.Errorf("invalid image reference %q", spec.Name)
+	}
+
+	progress := func(string) {}
+	if spec.OnPull != nil {
+		progress = spec.OnPull
+	}
+	if err := c.Runtime.Pull(ctx, spec.Name, progress); err != nil {
+		return nil, fmt.Errorf("error pulling image %s: %w", spec.Name, err)
+	}
+
+	var onLine func(pkg.OutputLine)
+	if spec.OnOutput != nil {
+		onLine = func(line pkg.OutputLine) { spec.OnOutput(line.Stream, line.Text) }
+	}
+	capture := pkg.NewCapture(spec.MaxOutputBytes, onLine)
+
+	name := "nunet-" + spec.JobID
+	if spec.JobID == "" {
+		name = fmt.Sprintf("nunet-%d", time.Now().UnixNano())
+	}
+	containerSpec := ContainerSpec{
+		Name:        name,
+		Image:       spec.Name,
+		Command:     spec.Args,
+		Env:         mapEnv(spec.Env, spec.Dir, ContainerWorkDir),
+		Mount:       spec.Dir,
+		CPUs:        spec.Resources.CPU,
+		MemoryBytes: int64(spec.Resources.RAM * (1 << 30)),
+		Stdin:       spec.Stdin,
+		Stdout:      capture.Writer(pkg.StreamStdout),
+		Stderr:      capture.Writer(pkg.StreamStderr),
+	}
+
+	fmt.Printf("Starting container %s from %s %s\n", name, spec.Name, strings.Join(spec.Args, " "))
+	startedAt := time.Now().UTC()
+	if err := c.Runtime.Run(ctx, containerSpec); err != nil {
+		c.Runtime.Remove(context.Background(), name)
+		return nil, fmt.Errorf("error starting container: %w", err)
+	}
+
+	execution := &containerExecution{
+		runtime: c.Runtime,
+		name:    name,
+		done:    make(chan struct{}),
+	}
+	go func() {
+		state, err := c.Runtime.Wait(context.Background(), name)
+		capture.Flush()
+		if err := c.Runtime.Remove(context.Background(), name); err != nil {
+			fmt.Println("Error removing container:", err)
+		}
+
+		result := pkg.Result{ExitCode: -1, Lines: capture.Lines()}
+		result.Truncated, result.DroppedBytes = capture.Truncated()
+		if err == nil {
+			result.ExitCode = state.ExitCode
+			result.Usage = containerUsage(state, startedAt)
+		}
+		execution.finish(result, state, err)
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				execution.kill(pkg.ErrCommandTimeout)
+			} else {
+				execution.kill(pkg.ErrCommandCancelled)
+			}
+		case <-execution.done:
+		}
+	}()
+	return execution, nil
+}
+
+// containerUsage returns the timing of a container, falling back to the time it was started at
+func containerUsage(state ContainerState, startedAt time.Time) *pkg.Usage {
+	usage := &pkg.Usage{StartedAt: state.StartedAt, EndedAt: state.FinishedAt}
+	if usage.StartedAt.IsZero() {
+		usage.StartedAt = startedAt
+	}
+	if usage.EndedAt.IsZero() || usage.EndedAt.Before(usage.StartedAt) {
+		usage.EndedAt = time.Now().UTC()
+	}
+	usage.WallMs = usage.EndedAt.Sub(usage.StartedAt).Milliseconds()
+	return usage
+}
+
+// containerExecution is a container started by the Container executor
+type containerExecution struct {
+	runtime ContainerRuntime
+	name    string
+	done    chan struct{}
+
+	mu         sync.Mutex
+	exited     bool
+	killReason error
+
+	result pkg.Result
+	err    error
+}
+
+// kill stops the container, unless it already exited
+func (e *containerExecution) kill(reason error) {
+	e.mu.Lock()
+	if e.exited || e.killReason != nil {
+		e.mu.Unlock()
+		return
+	}
+	e.killReason = reason
+	e.mu.Unlock()
+
+	if err := e.runtime.Kill(context.Background(), e.name); err != nil {
+		fmt.Println("Error killing container:", err)
+	}
+}
+
+// finish records how the container ended
+func (e *containerExecution) finish(result pkg.Result, state ContainerState, err error) {
+	e.mu.Lock()
+	e.exited = true
+	killReason := e.killReason
+	e.mu.Unlock()
+
+	switch {
+	case killReason != nil:
+		result.ExitCode = -1
+		err = killReason
+	case err != nil:
+		err = fmt.Errorf("error waiting for container: %w", err)
+	case state.OOMKilled:
+		err = ErrOutOfMemory
+	case state.ExitCode != 0:
+		err = fmt.Errorf("container exited with code %d", state.ExitCode)
+	}
+
+	e.result, e.err = result, err
+	close(e.done)
+}
+
+// PID returns 0, the container's processes belong to the container runtime
+func (e *containerExecution) PID() int {
+	return 0
+}
+
+// Kill stops the container; Wait then returns pkg.ErrCommandCancelled
+func (e *containerExecution) Kill() {
+	e.kill(pkg.ErrCommandCancelled)
+}
+
+// Wait blocks until the container exits and returns what it left behind
+func (e *containerExecution) Wait() (pkg.Result, error) {
+	<-e.done
+	return e.result, e.err
+}
@@ -0,0 +1,183 @@
+package executor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// knownContainerCLIs are the container engines looked up on the node's PATH, in order of preference
+var knownContainerCLIs = []string{"docker", "podman"}
+
+// CLIRuntime drives a docker compatible command line tool, such as docker or podman
+type CLIRuntime struct {
+	Binary string
+	UID    int // user containers run as, so the node can remove what they leave in its workspace; not set if -1 or root
+	GID    int
+
+	mu      sync.Mutex
+	running map[string]*exec.Cmd // container name -> the attached run command
+}
+
+// NewCLIRuntime returns a runtime driving the binary
+func NewCLIRuntime(binary string) *CLIRuntime {
+	return &CLIRuntime{
+		Binary:  binary,
+		UID:     os.Getuid(),
+		GID:     os.Getgid(),
+		running: make(map[string]*exec.Cmd),
+	}
+}
+
+// DetectCLIRuntime returns a runtime for the preferred binary, or the first known one
+// installed on the node if none is preferred
+func DetectCLIRuntime(preferred string) (*CLIRuntime, error) {
+	candidates := knownContainerCLIs
+	if preferred != "" {
+		candidates = []string{preferred}
+	}
+	for _, binary := range candidates {
+		if path, err := exec.LookPath(binary); err == nil {
+			return NewCLIRuntime(path), nil
+		}
+	}
+	return nil, fmt.Errorf("no container runtime found among %s", strings.Join(candidates, ", "))
+}
+
+// Pull pulls the image unless it's already present
+func (r *CLIRuntime) Pull(ctx context.Context, image string, progress func(status string)) error {
+	if err := exec.CommandContext(ctx, r.Binary, "image", "inspect", "--", image).Run(); err == nil {
+		return nil
+	}
+
+	cmd := exec.CommandContext(ctx, r.Binary, "pull", "--", image)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			progress(line)
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// Run starts the container attached, so its output is streamed as it's produced
+func (r *CLIRuntime) Run(ctx context.Context, spec ContainerSpec) error {
+	args := []string{"run", "--name", spec.Name, "--volume", spec.Mount + ":" + ContainerWorkDir, "--workdir", ContainerWorkDir}
+	if spec.CPUs > 0 {
+		args = append(args, "--cpus", strconv.Itoa(spec.CPUs))
+	}
+	if spec.MemoryBytes > 0 {
+		args = append(args, "--memory", strconv.FormatInt(spec.MemoryBytes, 10)+"b")
+	}
+	for _, env := range spec.Env {
+		args = append(args, "--env", env)
+	}
+	if spec.Stdin != nil {
+		args = append(args, "--interactive")
+	}
+	args = append(args, r.userArgs()...)
+	// Nothing after the separator is taken as an option, whatever the image reference looks like
+	args = append(args, "--", spec.Image)
+	args = append(args, spec.Command...)
+
+	cmd := exec.Command(r.Binary, args...)
+	cmd.Stdin = spec.Stdin
+	cmd.Stdout = spec.Stdout
+	cmd.Stderr = spec.Stderr
+	cmd.WaitDelay = time.Second
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.running[spec.Name] = cmd
+	r.mu.Unlock()
+	return nil
+}
+
+// userArgs are the options running the container as the node's user, so files it writes to the
+// mounted workspace belong to the node. Rootless podman maps that user into the container itself.
+func (r *CLIRuntime) userArgs() []string {
+	if r.UID <= 0 {
+		return nil // root removes whatever is left
+	}
+	if strings.HasPrefix(filepath.Base(r.Binary), "podman") {
+		return []string{"--userns", "keep-id"}
+	}
+	return []string{"--user", fmt.Sprintf("%d:%d", r.UID, r.GID)}
+}
+
+// cliState is the part of the container's state inspected
+type cliState struct {
+	ExitCode   int       `json:"ExitCode"`
+	OOMKilled  bool      `json:"OOMKilled"`
+	StartedAt  time.Time `json:"StartedAt"`
+	FinishedAt time.Time `json:"FinishedAt"`
+}
+
+// Wait waits for the attached run command to end and inspects how the container exited
+func (r *CLIRuntime) Wait(ctx context.Context, name string) (ContainerState, error) {
+	r.mu.Lock()
+	cmd, ok := r.running[name]
+	delete(r.running, name)
+	r.mu.Unlock()
+	if !ok {
+		return ContainerState{}, fmt.Errorf("container %s is not running", name)
+	}
+
+	// The exit status of the command mirrors the container's, but inspecting also tells OOM kills apart
+	waitErr := cmd.Wait()
+	output, err := exec.CommandContext(ctx, r.Binary, "inspect", "--format", "{{json .State}}", name).Output()
+	if err != nil {
+		if cmd.ProcessState == nil {
+			return ContainerState{}, waitErr
+		}
+		return ContainerState{ExitCode: cmd.ProcessState.ExitCode()}, nil
+	}
+
+	var state cliState
+	if err := json.Unmarshal(output, &state); err != nil {
+		return ContainerState{}, fmt.Errorf("error reading container state: %w", err)
+	}
+	return ContainerState(state), nil
+}
+
+// Kill stops the container
+func (r *CLIRuntime) Kill(ctx context.Context, name string) error {
+	output, err := exec.CommandContext(ctx, r.Binary, "kill", name).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Remove deletes the container
+func (r *CLIRuntime) Remove(ctx context.Context, name string) error {
+	output, err := exec.CommandContext(ctx, r.Binary, "rm", "--force", name).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
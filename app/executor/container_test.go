@@ -0,0 +1,245 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nunet/app/shared"
+	"nunet/pkg"
+)
+
+// fakeRuntime runs containers in-process: the command is echoed to stdout, along with stdin
+type fakeRuntime struct {
+	mu      sync.Mutex
+	images  map[string]bool
+	specs   map[string]ContainerSpec
+	exits   map[string]chan ContainerState
+	removed []string
+
+	exitCode  int
+	oomKilled bool
+	block     bool // keep containers running until killed
+}
+
+func newFakeRuntime(images ...string) *fakeRuntime {
+	r := &fakeRuntime{
+		images: make(map[string]bool),
+		specs:  make(map[string]ContainerSpec),
+		exits:  make(map[string]chan ContainerState),
+	}
+	for _, image := range images {
+		r.images[image] = true
+	}
+	return r
+}
+
+func (r *fakeRuntime) Pull(ctx context.Context, image string, progress func(status string)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.images[image] {
+		return nil
+	}
+	if strings.HasPrefix(image, "missing") {
+		return fmt.Errorf("manifest for %s not found", image)
+	}
+	progress("Pulling from " + image)
+	progress("Status: Downloaded newer image for " + image)
+	r.images[image] = true
+	return nil
+}
+
+func (r *fakeRuntime) Run(ctx context.Context, spec ContainerSpec) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.images[spec.Image] {
+		return fmt.Errorf("no such image %s", spec.Image)
+	}
+	r.specs[spec.Name] = spec
+	exit := make(chan ContainerState, 1)
+	r.exits[spec.Name] = exit
+
+	fmt.Fprintln(spec.Stdout, strings.Join(spec.Command, " "))
+	if spec.Stdin != nil {
+		io.Copy(spec.Stdout, spec.Stdin)
+	}
+	if !r.block {
+		exit <- ContainerState{ExitCode: r.exitCode, OOMKilled: r.oomKilled, StartedAt: time.Now(), FinishedAt: time.Now()}
+	}
+	return nil
+}
+
+func (r *fakeRuntime) Wait(ctx context.Context, name string) (ContainerState, error) {
+	r.mu.Lock()
+	exit := r.exits[name]
+	r.mu.Unlock()
+	return <-exit, nil
+}
+
+func (r *fakeRuntime) Kill(ctx context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	select {
+	case r.exits[name] <- ContainerState{ExitCode: 137}:
+	default:
+	}
+	return nil
+}
+
+func (r *fakeRuntime) Remove(ctx context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removed = append(r.removed, name)
+	return nil
+}
+
+func TestContainerRun(t *testing.T) {
+	dir := t.TempDir()
+	runtime := newFakeRuntime()
+
+	var pulled, streamed []string
+	result, err := Run(context.Background(), NewContainer(runtime), Spec{
+		JobID:     "job-1",
+		Resources: shared.Resources{CPU: 2, RAM: 0.5},
+		Command: pkg.Command{
+			Name:     "alpine:3",
+			Args:     []string{"echo", "hello"},
+			Env:      []string{"GREETING=hello", "TMPDIR=" + filepath.Join(dir, ".tmp"), "HOME=/root"},
+			Dir:      dir,
+			Stdin:    strings.NewReader("from stdin"),
+			OnOutput: func(stream, text string) { streamed = append(streamed, text) },
+		},
+		OnPull: func(status string) { pulled = append(pulled, status) },
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.ExitCode)
+	require.NotNil(t, result.Usage)
+	assert.Equal(t, []string{"echo hello", "from stdin"}, streamed)
+	assert.Len(t, pulled, 2)
+
+	spec := runtime.specs["nunet-job-1"]
+	assert.Equal(t, "alpine:3", spec.Image)
+	assert.Equal(t, dir, spec.Mount)
+	assert.Equal(t, 2, spec.CPUs)
+	assert.Equal(t, int64(512<<20), spec.MemoryBytes)
+	assert.Equal(t, []string{"GREETING=hello", "TMPDIR=/workspace/.tmp", "HOME=/root"}, spec.Env) // the submitter's paths are left alone
+	assert.Equal(t, []string{"nunet-job-1"}, runtime.removed)
+
+	// the image is only pulled once
+	pulled = nil
+	_, err = Run(context.Background(), NewContainer(runtime), Spec{JobID: "job-2", Command: pkg.Command{Name: "alpine:3", Dir: dir}})
+	require.NoError(t, err)
+	assert.Empty(t, pulled)
+
+	_, err = Run(context.Background(), NewContainer(runtime), Spec{JobID: "job-3", Command: pkg.Command{Name: "missing:1", Dir: dir}})
+	assert.ErrorContains(t, err, "error pulling image missing:1")
+
+	// references that could pass for options never reach the runtime
+	for _, image := range []string{"--privileged", "-v/:/host", "alpine 3"} {
+		_, err = Run(context.Background(), NewContainer(runtime), Spec{JobID: "job-4", Command: pkg.Command{Name: image, Dir: dir}})
+		assert.ErrorContains(t, err, "invalid image reference")
+	}
+	assert.NotContains(t, runtime.specs, "nunet-job-4")
+}
+
+func TestContainerExit(t *testing.T) {
+	runtime := newFakeRuntime("alpine:3")
+	spec := Spec{JobID: "job-1", Command: pkg.Command{Name: "alpine:3", Dir: t.TempDir()}}
+
+	runtime.exitCode = 3
+	result, err := Run(context.Background(), NewContainer(runtime), spec)
+	assert.EqualError(t, err, "container exited with code 3")
+	assert.Equal(t, 3, result.ExitCode)
+
+	runtime.exitCode, runtime.oomKilled = 137, true
+	result, err = Run(context.Background(), NewContainer(runtime), spec)
+	assert.ErrorIs(t, err, ErrOutOfMemory)
+	assert.Equal(t, 137, result.ExitCode)
+
+	// killed when the context is done or on request
+	runtime.oomKilled, runtime.block = false, true
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	result, err = Run(ctx, NewContainer(runtime), spec)
+	assert.ErrorIs(t, err, pkg.ErrCommandTimeout)
+	assert.Equal(t, -1, result.ExitCode)
+
+	execution, err := NewContainer(runtime).Start(context.Background(), spec)
+	require.NoError(t, err)
+	execution.Kill()
+	_, err = execution.Wait()
+	assert.ErrorIs(t, err, pkg.ErrCommandCancelled)
+}
+
+// fakeCLI is a docker lookalike logging its arguments; alpine:3 is the only image it has
+const fakeCLI = `#!/bin/sh
+echo "$@" >> "$0.log"
+case "$1" in
+image) [ "$4" = "alpine:3" ] ;;
+pull) echo "Pulling $3"; echo "Downloaded $3" ;;
+run) shift $(($# - 2)); echo "$@"; echo oops >&2 ;;
+inspect) echo '{"ExitCode":0,"OOMKilled":false,"StartedAt":"2024-01-01T00:00:00Z","FinishedAt":"2024-01-01T00:00:01Z"}' ;;
+esac
+`
+
+func TestCLIRuntime(t *testing.T) {
+	binary := filepath.Join(t.TempDir(), "docker")
+	require.NoError(t, os.WriteFile(binary, []byte(fakeCLI), 0o700))
+	runtime := NewCLIRuntime(binary)
+	runtime.UID, runtime.GID = 1000, 100
+
+	var pulled []string
+	require.NoError(t, runtime.Pull(context.Background(), "alpine:3", func(status string) { pulled = append(pulled, status) }))
+	assert.Empty(t, pulled)
+	require.NoError(t, runtime.Pull(context.Background(), "busybox", func(status string) { pulled = append(pulled, status) }))
+	assert.Equal(t, []string{"Pulling busybox", "Downloaded busybox"}, pulled)
+
+	var stdout, stderr strings.Builder
+	require.NoError(t, runtime.Run(context.Background(), ContainerSpec{
+		Name:        "nunet-job-1",
+		Image:       "alpine:3",
+		Command:     []string{"echo", "hi"},
+		Env:         []string{"A=1"},
+		Mount:       "/work",
+		CPUs:        1,
+		MemoryBytes: 1 << 20,
+		Stdout:      &stdout,
+		Stderr:      &stderr,
+	}))
+	state, err := runtime.Wait(context.Background(), "nunet-job-1")
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, state.FinishedAt.Sub(state.StartedAt))
+	assert.Equal(t, "echo hi\n", stdout.String())
+	assert.Equal(t, "oops\n", stderr.String())
+	require.NoError(t, runtime.Remove(context.Background(), "nunet-job-1"))
+
+	_, err = runtime.Wait(context.Background(), "nunet-job-1")
+	assert.Error(t, err)
+
+	log, err := os.ReadFile(binary + ".log")
+	require.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"image inspect -- alpine:3",
+		"image inspect -- busybox",
+		"pull -- busybox",
+		"run --name nunet-job-1 --volume /work:/workspace --workdir /workspace --cpus 1 --memory 1048576b --env A=1 --user 1000:100 -- alpine:3 echo hi",
+		"inspect --format {{json .State}} nunet-job-1",
+		"rm --force nunet-job-1",
+	}, "\n")+"\n", string(log))
+
+	// rootless podman maps the node's user into the container, and root needs no mapping
+	assert.Equal(t, []string{"--userns", "keep-id"}, (&CLIRuntime{Binary: "/usr/bin/podman", UID: 1000}).userArgs())
+	assert.Empty(t, (&CLIRuntime{Binary: binary, UID: 0}).userArgs())
+}
@@ -0,0 +1,113 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"nunet/app/shared"
+	"nunet/pkg"
+)
+
+// RuntimeProcess runs jobs as plain processes on the host. Jobs naming no runtime use it.
+const RuntimeProcess = "process"
+
+// Spec describes a job for an executor to run
+type Spec struct {
+	pkg.Command                     // program, arguments, the job's own environment, working directory, stdin and output handling
+	JobID       string              // the job being run
+	Workspace   string              // the job's workspace, holding its working directory and temporary files
+	Resources   shared.Resources    // what the job asked for
+	OnPull      func(status string) // optional, called with the progress of pulling what the job needs to start
+}
+
+// Executor starts jobs in one runtime
+type Executor interface {
+	// Name is the runtime requests name to pick this executor
+	Name() string
+	// Start launches the job and returns without waiting for it to finish. Output is passed
+	// to the spec's OnOutput as it's produced; the job is killed once the context is done.
+	Start(ctx context.Context, spec Spec) (Execution, error)
+}
+
+// Execution is a job started by an executor
+type Execution interface {
+	// PID identifies the job's main process on the node, 0 where there is none
+	PID() int
+	// Wait blocks until the job ends and returns its exit status, output and resource usage
+	Wait() (pkg.Result, error)
+	// Kill stops the job; Wait then returns pkg.ErrCommandCancelled
+	Kill()
+}
+
+// Set holds the executors a node runs jobs with, by runtime name
+type Set struct {
+	executors map[string]Executor
+}
+
+// NewSet returns a set of the executors
+func NewSet(executors ...Executor) *Set {
+	s := &Set{executors: make(map[string]Executor)}
+	for _, executor := range executors {
+		s.executors[executor.Name()] = executor
+	}
+	return s
+}
+
+// Get returns the executor for the runtime, RuntimeProcess if none is named
+func (s *Set) Get(runtime string) (Executor, error) {
+	if runtime == "" {
+		runtime = RuntimeProcess
+	}
+	executor, ok := s.executors[runtime]
+	if !ok {
+		return nil, fmt.Errorf("runtime %q is not supported by this node", runtime)
+	}
+	return executor, nil
+}
+
+// Names returns the runtimes of the set, sorted
+func (s *Set) Names() []string {
+	names := make([]string, 0, len(s.executors))
+	for name := range s.executors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// nodePathVars are the variables the node points at the job's workspace, such as its
+// temporary directory
+var nodePathVars = []string{"TMPDIR"}
+
+// mapEnv maps the node's path variables pointing inside dir to the same paths under root, as
+// seen from inside a sandbox, and drops them when they point elsewhere on the node. The
+// submitter's variables are passed through as they are.
+func mapEnv(env []string, dir, root string) []string {
+	var results []string
+	for _, entry := range env {
+		key, value, _ := strings.Cut(entry, "=")
+		if !slices.Contains(nodePathVars, key) || !filepath.IsAbs(value) {
+			results = append(results, entry)
+			continue
+		}
+		rel, err := filepath.Rel(dir, value)
+		if err != nil || (rel != "." && !filepath.IsLocal(rel)) {
+			continue
+		}
+		results = append(results, key+"="+filepath.ToSlash(filepath.Join(root, rel)))
+	}
+	return results
+}
+
+// Run starts the job and waits for it to end
+func Run(ctx context.Context, executor Executor, spec Spec) (pkg.Result, error) {
+	execution, err := executor.Start(ctx, spec)
+	if err != nil {
+		return pkg.Result{ExitCode: -1}, err
+	}
+	return execution.Wait()
+}
@@ -0,0 +1,70 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nunet/app/cgroup"
+	"nunet/app/shared"
+	"nunet/pkg"
+)
+
+func TestSet(t *testing.T) {
+	set := NewSet(NewProcess())
+	assert.Equal(t, []string{RuntimeProcess}, set.Names())
+
+	executor, err := set.Get("")
+	require.NoError(t, err)
+	assert.Equal(t, RuntimeProcess, executor.Name())
+
+	_, err = set.Get("wasm")
+	assert.EqualError(t, err, `runtime "wasm" is not supported by this node`)
+}
+
+func TestProcessRun(t *testing.T) {
+	var streamed []string
+	result, err := Run(context.Background(), NewProcess(), Spec{
+		JobID: "job-1",
+		Command: pkg.Command{
+			Name:     "sh",
+			Args:     []string{"-c", "echo hello; exit 2"},
+			OnOutput: func(stream, text string) { streamed = append(streamed, text) },
+		},
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 2, result.ExitCode)
+	assert.NotNil(t, result.Usage)
+	assert.Equal(t, []string{"hello"}, streamed)
+
+	result, err = Run(context.Background(), NewProcess(), Spec{Command: pkg.Command{Name: "nunet-no-such-program"}})
+	assert.Error(t, err)
+	assert.Equal(t, -1, result.ExitCode)
+}
+
+func TestWithCgroupStats(t *testing.T) {
+	stats := cgroup.Stats{UserCPUMs: 20, SystemCPUMs: 10, MemoryPeak: 1 << 20, OOMKills: 1}
+	failure := errors.New("error waiting for command to finish: signal: killed")
+
+	result, err := withCgroupStats(pkg.Result{ExitCode: -1, Usage: &pkg.Usage{UserCPUMs: 5}}, failure, stats)
+	assert.ErrorIs(t, err, ErrOutOfMemory)
+	assert.Equal(t, &pkg.Usage{UserCPUMs: 20, SystemCPUMs: 10, MemoryPeak: 1 << 20}, result.Usage)
+
+	// The job outlived the process that was killed, or was stopped for another reason
+	_, err = withCgroupStats(pkg.Result{}, nil, stats)
+	assert.NoError(t, err)
+	_, err = withCgroupStats(pkg.Result{}, pkg.ErrCommandTimeout, stats)
+	assert.ErrorIs(t, err, pkg.ErrCommandTimeout)
+
+	stats.OOMKills = 0
+	_, err = withCgroupStats(pkg.Result{}, failure, stats)
+	assert.Equal(t, failure, err)
+}
+
+func TestCgroupLimits(t *testing.T) {
+	assert.Equal(t, cgroup.Limits{CPUs: 2, MemoryBytes: 512 << 20, Pids: 2 * pidsPerCore}, cgroupLimits(shared.Resources{CPU: 2, RAM: 0.5}))
+	assert.Equal(t, cgroup.Limits{Pids: pidsPerCore}, cgroupLimits(shared.Resources{}))
+}
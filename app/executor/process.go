@@ -0,0 +1,150 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"nunet/app/cgroup"
+	"nunet/app/sandbox"
+	"nunet/app/shared"
+	"nunet/pkg"
+)
+
+// pidsPerCore is how many processes and threads a job may have per core it asked for
+const pidsPerCore = 256
+
+// Process runs jobs as processes of the node, each in its own process group
+type Process struct {
+	Sandbox *sandbox.Policy // confines jobs to their workspace when set
+	Cgroups *cgroup.Manager // limits jobs to the resources they asked for when set
+}
+
+// NewProcess returns the process executor
+func NewProcess() *Process {
+	return &Process{}
+}
+
+// NewSandboxedProcess returns the process executor running jobs sandboxed with the policy
+func NewSandboxedProcess(policy sandbox.Policy) *Process {
+	return &Process{Sandbox: &policy}
+}
+
+// Name returns RuntimeProcess
+func (p *Process) Name() string {
+	return RuntimeProcess
+}
+
+// Start launches the job's program on the host, inheriting the node's environment
+func (p *Process) Start(ctx context.Context, spec Spec) (Execution, error) {
+	command := spec.Command
+	command.Env = append(os.Environ(), spec.Env...)
+
+	var setups []func(cmd *exec.Cmd) error
+	if p.Sandbox != nil {
+		policy := *p.Sandbox
+		setups = append(setups, func(cmd *exec.Cmd) error { return sandbox.Apply(cmd, policy, spec.Workspace) })
+	}
+	var group *cgroup.Group
+	if p.Cgroups != nil {
+		name := spec.JobID
+		if name == "" {
+			name = fmt.Sprint(time.Now().UnixNano())
+		}
+		var err error
+		if group, err = p.Cgroups.Create(name, cgroupLimits(spec.Resources)); err != nil {
+			return nil, err
+		}
+		setups = append(setups, group.Setup)
+	}
+	if len(setups) > 0 {
+		command.Setup = func(cmd *exec.Cmd) error {
+			for _, setup := range setups {
+				if err := setup(cmd); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	process, err := pkg.StartCmd(ctx, command)
+	if err != nil {
+		if group != nil {
+			group.Remove()
+		}
+		return nil, err
+	}
+	if group == nil {
+		return process, nil
+	}
+	return &cgroupExecution{Process: process, group: group}, nil
+}
+
+// cgroupLimits returns the limits of a job asking for the resources
+func cgroupLimits(resources shared.Resources) cgroup.Limits {
+	return cgroup.Limits{
+		CPUs:        float64(resources.CPU),
+		MemoryBytes: int64(resources.RAM * (1 << 30)),
+		Pids:        int64(max(resources.CPU, 1)) * pidsPerCore,
+	}
+}
+
+// cgroupExecution is a process started in its own cgroup, removed once the process exits
+type cgroupExecution struct {
+	*pkg.Process
+	group *cgroup.Group
+
+	once   sync.Once
+	result pkg.Result
+	err    error
+}
+
+// Kill stops the process and everything else in its cgroup; Wait then returns pkg.ErrCommandCancelled
+func (e *cgroupExecution) Kill() {
+	e.Process.Kill()
+	e.group.Kill()
+}
+
+// Wait blocks until the process exits and returns what it left behind, with the usage of its cgroup
+func (e *cgroupExecution) Wait() (pkg.Result, error) {
+	e.once.Do(func() {
+		result, err := e.Process.Wait()
+		// Processes that left the process group are still in the cgroup
+		if err := e.group.Kill(); err != nil {
+			fmt.Println("Error killing cgroup:", err)
+		}
+		if stats, statsErr := e.group.Stats(); statsErr == nil {
+			result, err = withCgroupStats(result, err, stats)
+		} else {
+			fmt.Println("Error reading cgroup usage:", statsErr)
+		}
+		if err := e.group.Remove(); err != nil {
+			fmt.Println("Error removing cgroup:", err)
+		}
+		e.result, e.err = result, err
+	})
+	return e.result, e.err
+}
+
+// withCgroupStats completes the result of a process with what its cgroup used. A process that
+// failed after one in its cgroup was killed for going over the memory limit ran out of memory,
+// unless it was killed for another reason.
+func withCgroupStats(result pkg.Result, err error, stats cgroup.Stats) (pkg.Result, error) {
+	if result.Usage != nil {
+		usage := *result.Usage
+		usage.UserCPUMs = stats.UserCPUMs
+		usage.SystemCPUMs = stats.SystemCPUMs
+		usage.MemoryPeak = stats.MemoryPeak
+		result.Usage = &usage
+	}
+	if err != nil && stats.OOMKills > 0 &&
+		!errors.Is(err, pkg.ErrCommandTimeout) && !errors.Is(err, pkg.ErrCommandCancelled) {
+		err = ErrOutOfMemory
+	}
+	return result, err
+}
@@ -0,0 +1,54 @@
+// Command wasm is run by the tests of the wasm executor, built with GOOS=wasip1 GOARCH=wasm
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+func main() {
+	switch os.Args[1] {
+	case "files":
+		in, err := os.ReadFile("in.txt")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		stdin, _ := io.ReadAll(os.Stdin)
+		fmt.Println(string(in), string(stdin), os.Getenv("GREETING"), os.Getenv("TMPDIR"), os.Getenv("HOME"))
+		if _, err := os.ReadFile("/etc/hostname"); err == nil {
+			fmt.Println("escaped the workspace")
+		}
+		os.WriteFile("out.txt", []byte("written"), 0o644)
+	case "links":
+		// every attempt at reaching the node's files through links must fail
+		if err := os.Symlink("/etc", "made"); err == nil {
+			fmt.Println("made a symlink")
+		}
+		if err := os.Link("/etc/hostname", "hard"); err == nil {
+			fmt.Println("made a hard link")
+		}
+		for _, path := range []string{"planted/hostname", "planted", "dangling/new.txt", "inside/in.txt"} {
+			if _, err := os.ReadFile(path); err == nil {
+				fmt.Println("read", path)
+			}
+		}
+		if err := os.WriteFile("dangling", []byte("escaped"), 0o644); err == nil {
+			fmt.Println("wrote dangling")
+		}
+	case "exit":
+		code, _ := strconv.Atoi(os.Args[2])
+		os.Exit(code)
+	case "spin":
+		for i := 0; ; i++ {
+			fmt.Fprint(io.Discard, i)
+		}
+	case "alloc":
+		var chunks [][]byte
+		for {
+			chunks = append(chunks, make([]byte, 16<<20))
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package executor
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+	"github.com/tetratelabs/wazero/experimental/sysfs"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+
+	"nunet/pkg"
+)
+
+// RuntimeWasm runs WebAssembly modules using WASI in a sandbox
+const RuntimeWasm = "wasm"
+
+// ErrCallLimitExceeded is returned when a module is stopped for making too many function calls
+var ErrCallLimitExceeded = errors.New("wasm module made too many function calls")
+
+const (
+	wasmPageSize      = 64 << 10
+	wasmMaxPages      = 1 << 16 // 4 GiB, all 32-bit memories can address
+	wasmGuestRoot     = "/"
+	wasmModuleMaxSize = 256 << 20
+)
+
+// Wasm runs jobs whose program is a WASI module shipped in the job's working directory.
+// The module sees the working directory as its root filesystem and nothing else of the
+// node; its memory and the number of function calls it makes are limited. Calls are what's
+// counted, not instructions, so a module looping without calling anything runs until the
+// job's timeout.
+type Wasm struct {
+	MaxMemoryBytes int64  // memory a module may grow to, further capped by the job's ram; 4 GiB if 0
+	MaxCalls       uint64 // function calls a module may make, unlimited if 0
+
+	cache wazero.CompilationCache
+}
+
+// NewWasm returns the WebAssembly executor
+func NewWasm(maxMemoryBytes int64, maxCalls uint64) *Wasm {
+	return &Wasm{
+		MaxMemoryBytes: maxMemoryBytes,
+		MaxCalls:       maxCalls,
+		cache:          wazero.NewCompilationCache(),
+	}
+}
+
+// Name returns RuntimeWasm
+func (w *Wasm) Name() string {
+	return RuntimeWasm
+}
+
+// Start compiles the module named by the spec's program and runs its _start function
+func (w *Wasm) Start(ctx context.Context, spec Spec) (Execution, error) {
+	if spec.Name == "" || !filepath.IsLocal(spec.Name) {
+		return nil, fmt.Errorf("wasm module %q must be a path inside the job's working directory", spec.Name)
+	}
+	info, err := os.Stat(filepath.Join(spec.Dir, spec.Name))
+	if err != nil {
+		return nil, fmt.Errorf("error reading wasm module: %w", err)
+	}
+	if info.Size() > wasmModuleMaxSize {
+		return nil, fmt.Errorf("wasm module is larger than %d bytes", wasmModuleMaxSize)
+	}
+	binary, err := os.ReadFile(filepath.Join(spec.Dir, spec.Name))
+	if err != nil {
+		return nil, fmt.Errorf("error reading wasm module: %w", err)
+	}
+	workspace, err := newWorkspaceFS(spec.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("error opening working directory: %w", err)
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	execution := &wasmExecution{cancel: cancel, done: make(chan struct{})}
+
+	config := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(w.memoryPages(spec)).
+		WithCloseOnContextDone(true).
+		WithCompilationCache(w.cache)
+	runtime := wazero.NewRuntimeWithConfig(runCtx, config)
+
+	compileCtx := runCtx
+	if w.MaxCalls > 0 {
+		compileCtx = experimental.WithFunctionListenerFactory(runCtx, callCounter)
+		runCtx = context.WithValue(runCtx, callCountKey{}, &callCount{limit: w.MaxCalls, execution: execution})
+	}
+	if _, err := wasi_snapshot_preview1.Instantiate(runCtx, runtime); err != nil {
+		runtime.Close(context.Background())
+		cancel()
+		return nil, fmt.Errorf("error setting up wasi: %w", err)
+	}
+	module, err := runtime.CompileModule(compileCtx, binary)
+	if err != nil {
+		runtime.Close(context.Background())
+		cancel()
+		return nil, fmt.Errorf("error compiling wasm module: %w", err)
+	}
+
+	var onLine func(pkg.OutputLine)
+	if spec.OnOutput != nil {
+		onLine = func(line pkg.OutputLine) { spec.OnOutput(line.Stream, line.Text) }
+	}
+	capture := pkg.NewCapture(spec.MaxOutputBytes, onLine)
+
+	moduleConfig := wazero.NewModuleConfig().
+		WithName(spec.JobID).
+		WithArgs(append([]string{spec.Name}, spec.Args...)...).
+		WithStdout(capture.Writer(pkg.StreamStdout)).
+		WithStderr(capture.Writer(pkg.StreamStderr)).
+		WithFSConfig(wazero.NewFSConfig().(sysfs.FSConfig).WithSysFSMount(workspace, wasmGuestRoot)).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader)
+	if spec.Stdin != nil {
+		moduleConfig = moduleConfig.WithStdin(spec.Stdin)
+	}
+	for _, env := range mapEnv(spec.Env, spec.Dir, wasmGuestRoot) {
+		key, value, _ := strings.Cut(env, "=")
+		moduleConfig = moduleConfig.WithEnv(key, value)
+	}
+
+	fmt.Printf("Executing wasm module: %s %s\n", spec.Name, strings.Join(spec.Args, " "))
+	go func() {
+		defer cancel()
+		defer runtime.Close(context.Background())
+
+		startedAt := time.Now().UTC()
+		_, err := runtime.InstantiateModule(runCtx, module, moduleConfig)
+		endedAt := time.Now().UTC()
+		capture.Flush()
+
+		result := pkg.Result{
+			ExitCode: 0,
+			Usage:    &pkg.Usage{StartedAt: startedAt, EndedAt: endedAt, WallMs: endedAt.Sub(startedAt).Milliseconds()},
+			Lines:    capture.Lines(),
+		}
+		result.Truncated, result.DroppedBytes = capture.Truncated()
+		execution.finish(ctx, result, err)
+	}()
+	return execution, nil
+}
+
+// memoryPages returns the pages a module may grow its memory to
+func (w *Wasm) memoryPages(spec Spec) uint32 {
+	limit := w.MaxMemoryBytes
+	if ram := int64(spec.Resources.RAM * (1 << 30)); ram > 0 && (limit <= 0 || ram < limit) {
+		limit = ram
+	}
+	if limit <= 0 || limit/wasmPageSize >= wasmMaxPages {
+		return wasmMaxPages
+	}
+	return uint32(max(limit/wasmPageSize, 1))
+}
+
+// wasmExecution is a module run by the Wasm executor
+type wasmExecution struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu         sync.Mutex
+	killReason error
+
+	result pkg.Result
+	err    error
+}
+
+// kill stops the module, unless it was already stopped
+func (e *wasmExecution) kill(reason error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.killReason == nil {
+		e.killReason = reason
+	}
+	e.cancel()
+}
+
+// finish records how the module ended
+func (e *wasmExecution) finish(ctx context.Context, result pkg.Result, err error) {
+	e.mu.Lock()
+	killReason := e.killReason
+	e.mu.Unlock()
+
+	var exitErr *sys.ExitError
+	switch {
+	case err != nil && killReason != nil:
+		result.ExitCode = -1
+		err = killReason
+	case err != nil && ctx.Err() != nil:
+		result.ExitCode = -1
+		err = pkg.ErrCommandCancelled
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = pkg.ErrCommandTimeout
+		}
+	case errors.As(err, &exitErr):
+		result.ExitCode = int(exitErr.ExitCode())
+		err = nil
+		if result.ExitCode != 0 {
+			err = fmt.Errorf("wasm module exited with code %d", result.ExitCode)
+		}
+	case err != nil:
+		result.ExitCode = -1
+		err = fmt.Errorf("wasm module failed: %w", err)
+	}
+
+	e.result, e.err = result, err
+	close(e.done)
+}
+
+// PID returns 0, modules run inside the node's process
+func (e *wasmExecution) PID() int {
+	return 0
+}
+
+// Kill stops the module; Wait then returns pkg.ErrCommandCancelled
+func (e *wasmExecution) Kill() {
+	e.kill(pkg.ErrCommandCancelled)
+}
+
+// Wait blocks until the module ends and returns what it left behind
+func (e *wasmExecution) Wait() (pkg.Result, error) {
+	<-e.done
+	return e.result, e.err
+}
+
+// callCountKey is the context key of the calls a running module may still make
+type callCountKey struct{}
+
+// callCount counts the function calls of one run of a module
+type callCount struct {
+	limit     uint64
+	made      atomic.Uint64
+	execution *wasmExecution
+}
+
+// add counts a call, stopping the module once it goes over the limit
+func (c *callCount) add() {
+	if c.made.Add(1) == c.limit+1 {
+		c.execution.kill(ErrCallLimitExceeded)
+	}
+}
+
+// callCounter counts every function call of a module. It's compiled into the module, so the
+// count is taken from the context each run is started with. Loops that make no calls aren't
+// counted; only the job's timeout stops those.
+var callCounter = experimental.FunctionListenerFactoryFunc(func(api.FunctionDefinition) experimental.FunctionListener {
+	return experimental.FunctionListenerFunc(func(ctx context.Context, _ api.Module, _ api.FunctionDefinition, _ []uint64, _ experimental.StackIterator) {
+		if count, ok := ctx.Value(callCountKey{}).(*callCount); ok {
+			count.add()
+		}
+	})
+})
@@ -0,0 +1,145 @@
+package executor
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	experimentalsys "github.com/tetratelabs/wazero/experimental/sys"
+	"github.com/tetratelabs/wazero/experimental/sysfs"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// workspaceFS is the filesystem of a wasm module: the job's working directory, which the module
+// can't leave. Modules may not create links, and links already there are only followed while
+// they point inside the directory.
+type workspaceFS struct {
+	experimentalsys.FS
+	root string // the directory, with its own links resolved
+}
+
+// newWorkspaceFS returns the filesystem of the directory
+func newWorkspaceFS(dir string) (*workspaceFS, error) {
+	root, err := filepath.Abs(dir)
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &workspaceFS{FS: sysfs.DirFS(root), root: root}, nil
+}
+
+// contains tells whether path, relative to the root, leads inside it once every link is
+// followed. Links that lead nowhere are refused, as creating a file through one would
+// create it wherever it points.
+func (w *workspaceFS) contains(path string) bool {
+	host := filepath.Join(w.root, path)
+	missing := ""
+	for {
+		resolved, err := filepath.EvalSymlinks(host)
+		if err == nil {
+			rel, err := filepath.Rel(w.root, filepath.Join(resolved, missing))
+			return err == nil && (rel == "." || filepath.IsLocal(rel))
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return false
+		}
+		if _, err := os.Lstat(host); err == nil {
+			return false
+		}
+		parent := filepath.Dir(host)
+		if parent == host {
+			return false
+		}
+		missing = filepath.Join(filepath.Base(host), missing)
+		host = parent
+	}
+}
+
+// containsParent tells whether the directory holding path leads inside the root, for calls
+// acting on path itself rather than on what it links to
+func (w *workspaceFS) containsParent(path string) bool {
+	return w.contains(filepath.Dir(path))
+}
+
+func (w *workspaceFS) OpenFile(path string, flag experimentalsys.Oflag, perm fs.FileMode) (experimentalsys.File, experimentalsys.Errno) {
+	if !w.contains(path) {
+		return nil, experimentalsys.EPERM
+	}
+	return w.FS.OpenFile(path, flag, perm)
+}
+
+func (w *workspaceFS) Lstat(path string) (sys.Stat_t, experimentalsys.Errno) {
+	if !w.containsParent(path) {
+		return sys.Stat_t{}, experimentalsys.EPERM
+	}
+	return w.FS.Lstat(path)
+}
+
+func (w *workspaceFS) Stat(path string) (sys.Stat_t, experimentalsys.Errno) {
+	if !w.contains(path) {
+		return sys.Stat_t{}, experimentalsys.EPERM
+	}
+	return w.FS.Stat(path)
+}
+
+func (w *workspaceFS) Mkdir(path string, perm fs.FileMode) experimentalsys.Errno {
+	if !w.contains(path) {
+		return experimentalsys.EPERM
+	}
+	return w.FS.Mkdir(path, perm)
+}
+
+func (w *workspaceFS) Chmod(path string, perm fs.FileMode) experimentalsys.Errno {
+	if !w.contains(path) {
+		return experimentalsys.EPERM
+	}
+	return w.FS.Chmod(path, perm)
+}
+
+func (w *workspaceFS) Rename(from, to string) experimentalsys.Errno {
+	if !w.containsParent(from) || !w.containsParent(to) {
+		return experimentalsys.EPERM
+	}
+	return w.FS.Rename(from, to)
+}
+
+func (w *workspaceFS) Rmdir(path string) experimentalsys.Errno {
+	if !w.containsParent(path) {
+		return experimentalsys.EPERM
+	}
+	return w.FS.Rmdir(path)
+}
+
+func (w *workspaceFS) Unlink(path string) experimentalsys.Errno {
+	if !w.containsParent(path) {
+		return experimentalsys.EPERM
+	}
+	return w.FS.Unlink(path)
+}
+
+// Link is refused: a hard link to a file outside the workspace would let the module reach it
+func (w *workspaceFS) Link(oldPath, newPath string) experimentalsys.Errno {
+	return experimentalsys.EPERM
+}
+
+// Symlink is refused: a link could point anywhere on the node
+func (w *workspaceFS) Symlink(oldPath, linkName string) experimentalsys.Errno {
+	return experimentalsys.EPERM
+}
+
+func (w *workspaceFS) Readlink(path string) (string, experimentalsys.Errno) {
+	if !w.containsParent(path) {
+		return "", experimentalsys.EPERM
+	}
+	return w.FS.Readlink(path)
+}
+
+func (w *workspaceFS) Utimens(path string, atim, mtim int64) experimentalsys.Errno {
+	if !w.contains(path) {
+		return experimentalsys.EPERM
+	}
+	return w.FS.Utimens(path, atim, mtim)
+}
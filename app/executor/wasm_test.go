@@ -0,0 +1,127 @@
+package executor
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nunet/app/shared"
+	"nunet/pkg"
+)
+
+// buildTestModule compiles testdata/wasm into the directory as test.wasm
+func buildTestModule(t *testing.T, dir string) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("building a wasm module takes a while")
+	}
+
+	cmd := exec.Command("go", "build", "-o", filepath.Join(dir, "test.wasm"), "./testdata/wasm")
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+}
+
+func TestWasmRun(t *testing.T) {
+	dir := t.TempDir()
+	buildTestModule(t, dir)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "in.txt"), []byte("input"), 0o600))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "tmp"), 0o700))
+
+	var streamed []string
+	result, err := Run(context.Background(), NewWasm(0, 0), Spec{
+		JobID: "job-1",
+		Command: pkg.Command{
+			Name:     "test.wasm",
+			Args:     []string{"files"},
+			Env:      []string{"GREETING=hello", "TMPDIR=" + filepath.Join(dir, "tmp"), "HOME=/root"},
+			Dir:      dir,
+			Stdin:    strings.NewReader("stdin"),
+			OnOutput: func(stream, text string) { streamed = append(streamed, text) },
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.ExitCode)
+	require.NotNil(t, result.Usage)
+	require.Len(t, result.Lines, 1)
+	assert.Equal(t, "input stdin hello /tmp /root", result.Lines[0].Text) // no escape, only TMPDIR mapped into the sandbox
+	assert.Equal(t, []string{result.Lines[0].Text}, streamed)
+
+	written, err := os.ReadFile(filepath.Join(dir, "out.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "written", string(written))
+
+	result, err = Run(context.Background(), NewWasm(0, 0), Spec{Command: pkg.Command{Name: "test.wasm", Args: []string{"exit", "3"}, Dir: dir}})
+	assert.Error(t, err)
+	assert.Equal(t, 3, result.ExitCode)
+
+	_, err = Run(context.Background(), NewWasm(0, 0), Spec{Command: pkg.Command{Name: "../test.wasm", Dir: dir}})
+	assert.Error(t, err)
+}
+
+func TestWasmLinks(t *testing.T) {
+	dir := t.TempDir()
+	buildTestModule(t, dir)
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "in.txt"), []byte("input"), 0o600))
+	require.NoError(t, os.Symlink("/etc", filepath.Join(dir, "planted")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "missing"), filepath.Join(dir, "dangling")))
+	require.NoError(t, os.Symlink(dir, filepath.Join(dir, "inside")))
+
+	result, err := Run(context.Background(), NewWasm(0, 0), Spec{Command: pkg.Command{Name: "test.wasm", Args: []string{"links"}, Dir: dir}})
+	require.NoError(t, err)
+	var lines []string
+	for _, line := range result.Lines {
+		lines = append(lines, line.Text)
+	}
+	assert.Equal(t, []string{"read inside/in.txt"}, lines) // only links staying in the workspace are followed
+
+	_, err = os.Lstat(filepath.Join(outside, "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestWasmLimits(t *testing.T) {
+	dir := t.TempDir()
+	buildTestModule(t, dir)
+
+	// function calls
+	result, err := Run(context.Background(), NewWasm(0, 100_000), Spec{Command: pkg.Command{Name: "test.wasm", Args: []string{"spin"}, Dir: dir}})
+	assert.ErrorIs(t, err, ErrCallLimitExceeded)
+	assert.Equal(t, -1, result.ExitCode)
+
+	// memory, from the node's limit and the job's ram
+	result, err = Run(context.Background(), NewWasm(64<<20, 0), Spec{Command: pkg.Command{Name: "test.wasm", Args: []string{"alloc"}, Dir: dir}})
+	assert.Error(t, err)
+	assert.NotEqual(t, 0, result.ExitCode)
+	_, err = Run(context.Background(), NewWasm(0, 0), Spec{
+		Command:   pkg.Command{Name: "test.wasm", Args: []string{"alloc"}, Dir: dir},
+		Resources: shared.Resources{RAM: 0.0625},
+	})
+	assert.Error(t, err)
+
+	// timeout and kill
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = Run(ctx, NewWasm(0, 0), Spec{Command: pkg.Command{Name: "test.wasm", Args: []string{"spin"}, Dir: dir}})
+	assert.ErrorIs(t, err, pkg.ErrCommandTimeout)
+
+	execution, err := NewWasm(0, 0).Start(context.Background(), Spec{Command: pkg.Command{Name: "test.wasm", Args: []string{"spin"}, Dir: dir}})
+	require.NoError(t, err)
+	execution.Kill()
+	_, err = execution.Wait()
+	assert.ErrorIs(t, err, pkg.ErrCommandCancelled)
+}
+
+func TestWasmMemoryPages(t *testing.T) {
+	assert.Equal(t, uint32(wasmMaxPages), NewWasm(0, 0).memoryPages(Spec{}))
+	assert.Equal(t, uint32(16), NewWasm(1<<20, 0).memoryPages(Spec{}))
+	assert.Equal(t, uint32(16384), NewWasm(0, 0).memoryPages(Spec{Resources: shared.Resources{RAM: 1}}))
+	assert.Equal(t, uint32(16), NewWasm(1<<20, 0).memoryPages(Spec{Resources: shared.Resources{RAM: 1}}))
+}
@@ -0,0 +1,117 @@
+package job
+
+import (
+	"sync"
+	"time"
+
+	"nunet/app/shared"
+	"nunet/pkg"
+)
+
+// Admission decides whether this node can take a job, reserving the resources of
+// the jobs it admitted until they finish
+type Admission struct {
+	MaxDuration time.Duration // longest run time a job may ask for
+
+	mu       sync.Mutex
+	reserved map[string]shared.Resources
+	compute  func() (*pkg.AvailableCompute, error)
+}
+
+// NewAdmission creates an admission controller checking jobs against the node's free compute,
+// with disk measured where the workspaces of jobs are
+func NewAdmission(maxDuration time.Duration, workspaceDir string) *Admission {
+	return &Admission{
+		MaxDuration: maxDuration,
+		reserved:    make(map[string]shared.Resources),
+		compute:     func() (*pkg.AvailableCompute, error) { return pkg.GetComputeAvailable(workspaceDir) },
+	}
+}
+
+// Reserve admits the job and holds its resources, or explains why it can't be run
+func (a *Admission) Reserve(jobID string, resources shared.Resources) (*shared.Rejection, error) {
+	if maxDuration := a.MaxDuration.Seconds(); float64(resources.MaxDuration) > maxDuration {
+		return &shared.Rejection{
+			Reason:    "exceeds node limit",
+			Resource:  "max_duration",
+			Requested: float64(resources.MaxDuration),
+			Available: maxDuration,
+		}, nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	available, err := a.available()
+	if err != nil {
+		return nil, err
+	}
+
+	checks := []struct {
+		resource  string
+		requested float64
+		available float64
+	}{
+		{"cpu", float64(resources.CPU), float64(available.FreeCPUCores)},
+		{"ram", resources.RAM, available.FreeRAM},
+		{"disk", resources.Disk, available.FreeDisk},
+	}
+	for _, check := range checks {
+		if check.requested > check.available {
+			return &shared.Rejection{
+				Reason:    "insufficient resources",
+				Resource:  check.resource,
+				Requested: check.requested,
+				Available: check.available,
+			}, nil
+		}
+	}
+
+	a.reserved[jobID] = resources
+	return nil, nil
+}
+
+// Timeout returns how long the job may run: its requested max duration, or the node's limit if it set none
+func (a *Admission) Timeout(resources shared.Resources) time.Duration {
+	if resources.MaxDuration > 0 {
+		return time.Duration(resources.MaxDuration) * time.Second
+	}
+	return a.MaxDuration
+}
+
+// Release frees the resources held for the job
+func (a *Admission) Release(jobID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.reserved, jobID)
+}
+
+// Total returns the node's free compute, leaving out what admitted jobs reserved
+func (a *Admission) Total() (*pkg.AvailableCompute, error) {
+	return a.compute()
+}
+
+// Available returns the node's free compute minus what admitted jobs reserved
+func (a *Admission) Available() (*pkg.AvailableCompute, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.available()
+}
+
+// available must be called with the lock held
+func (a *Admission) available() (*pkg.AvailableCompute, error) {
+	compute, err := a.compute()
+	if err != nil {
+		return nil, err
+	}
+
+	var reserved shared.Resources
+	for _, resources := range a.reserved {
+		reserved = reserved.Add(resources)
+	}
+
+	compute.FreeCPUCores -= reserved.CPU
+	compute.FreeRAM -= reserved.RAM
+	compute.FreeDisk -= reserved.Disk
+	return compute, nil
+}
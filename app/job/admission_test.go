@@ -0,0 +1,50 @@
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nunet/app/shared"
+	"nunet/pkg"
+)
+
+func TestAdmissionReserve(t *testing.T) {
+	admission := NewAdmission(time.Minute, t.TempDir())
+	admission.compute = func() (*pkg.AvailableCompute, error) {
+		return &pkg.AvailableCompute{FreeCPUCores: 4, FreeRAM: 8, FreeDisk: 100}, nil
+	}
+
+	rejection, err := admission.Reserve("a", shared.Resources{CPU: 3, RAM: 4})
+	require.NoError(t, err)
+	assert.Nil(t, rejection)
+
+	// only one core left once job a is reserved
+	rejection, err = admission.Reserve("b", shared.Resources{CPU: 2})
+	require.NoError(t, err)
+	require.NotNil(t, rejection)
+	assert.Equal(t, "cpu", rejection.Resource)
+	assert.Equal(t, 1.0, rejection.Available)
+
+	rejection, err = admission.Reserve("c", shared.Resources{Disk: 200})
+	require.NoError(t, err)
+	require.NotNil(t, rejection)
+	assert.Equal(t, "disk", rejection.Resource)
+
+	rejection, err = admission.Reserve("d", shared.Resources{MaxDuration: 120})
+	require.NoError(t, err)
+	require.NotNil(t, rejection)
+	assert.Equal(t, "max_duration", rejection.Resource)
+
+	admission.Release("a")
+	rejection, err = admission.Reserve("b", shared.Resources{CPU: 2})
+	require.NoError(t, err)
+	assert.Nil(t, rejection)
+
+	available, err := admission.Available()
+	require.NoError(t, err)
+	assert.Equal(t, 2, available.FreeCPUCores)
+	assert.Equal(t, 8.0, available.FreeRAM)
+}
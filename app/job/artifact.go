@@ -0,0 +1,136 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"nunet/app/shared"
+)
+
+// artifactFetchTimeout bounds how long the submitter spends downloading a job's artifacts
+const artifactFetchTimeout = 10 * time.Minute
+
+// collectArtifacts stores the files of the workspace matching the job's artifact patterns.
+// Only regular files are collected, and only where every directory leading to them, once its
+// links are followed, stays inside the workspace: the job may have made links to anywhere.
+func (j *Job) collectArtifacts(request shared.DeployRequest, workspace *Workspace) ([]shared.File, error) {
+	if err := shared.ValidateArtifactPatterns(request.Artifacts); err != nil {
+		return nil, err
+	}
+	root, err := filepath.EvalSymlinks(workspace.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving working directory: %w", err)
+	}
+
+	seen := map[string]bool{}
+	var artifacts []shared.File
+	var total int64
+	for _, pattern := range request.Artifacts {
+		matches, err := filepath.Glob(filepath.Join(workspace.Dir, pattern))
+		if err != nil {
+			return artifacts, fmt.Errorf("invalid artifact pattern %q: %w", pattern, err)
+		}
+
+		for _, match := range matches {
+			name, err := filepath.Rel(workspace.Dir, match)
+			if err != nil || !filepath.IsLocal(name) || seen[name] {
+				continue
+			}
+			info, err := os.Lstat(match)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			resolved, err := filepath.EvalSymlinks(match)
+			if err != nil {
+				continue
+			}
+			if rel, err := filepath.Rel(root, resolved); err != nil || !filepath.IsLocal(rel) {
+				continue
+			}
+			seen[name] = true
+
+			total += info.Size()
+			if j.MaxArtifactBytes > 0 && total > j.MaxArtifactBytes {
+				return artifacts, fmt.Errorf("%w: a job may bring back at most %d bytes", shared.ErrFilesTooLarge, j.MaxArtifactBytes)
+			}
+			artifact, err := j.storeArtifact(filepath.ToSlash(name), resolved)
+			if err != nil {
+				return artifacts, fmt.Errorf("error collecting artifact %s: %w", name, err)
+			}
+			artifacts = append(artifacts, artifact)
+		}
+	}
+	return artifacts, nil
+}
+
+func (j *Job) storeArtifact(name, path string) (shared.File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return shared.File{}, err
+	}
+	defer file.Close()
+
+	c, size, err := j.Blobs.Put(file, j.MaxArtifactBytes)
+	if err != nil {
+		return shared.File{}, err
+	}
+	return shared.File{Path: name, Size: size, CID: c.String()}, nil
+}
+
+// fetchArtifacts downloads the artifacts listed in the response this node doesn't hold yet,
+// from the peer that ran the job or any other peer that has them
+func (j *Job) fetchArtifacts(response shared.DeployResponse) error {
+	if err := shared.ValidateFiles(response.Artifacts); err != nil {
+		return err
+	}
+
+	var providers []peer.ID
+	if target, err := peer.Decode(response.TargetPeerID); err == nil {
+		providers = append(providers, target)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), artifactFetchTimeout)
+	defer cancel()
+
+	for _, artifact := range response.Artifacts {
+		c, _ := cid.Decode(artifact.CID)
+		if err := j.Exchange.Fetch(ctx, c, providers...); err != nil {
+			return fmt.Errorf("error fetching artifact %s: %w", artifact.Path, err)
+		}
+	}
+	return nil
+}
+
+// OpenArtifact returns the contents of one of the job's artifacts
+func (j *Job) OpenArtifact(id, name string) (io.ReadCloser, shared.File, error) {
+	record, ok := j.Registry.Get(id)
+	if !ok {
+		return nil, shared.File{}, shared.ErrJobNotFound
+	}
+	if record.Response == nil {
+		return nil, shared.File{}, shared.ErrNoArtifact
+	}
+
+	for _, artifact := range record.Response.Artifacts {
+		if artifact.Path != name {
+			continue
+		}
+		c, err := cid.Decode(artifact.CID)
+		if err != nil {
+			return nil, shared.File{}, fmt.Errorf("%w: %s has an invalid cid", shared.ErrNoArtifact, name)
+		}
+		file, _, err := j.Blobs.Open(c)
+		if err != nil {
+			return nil, shared.File{}, fmt.Errorf("%w: %s is no longer available", shared.ErrNoArtifact, name)
+		}
+		return file, artifact, nil
+	}
+	return nil, shared.File{}, shared.ErrNoArtifact
+}
@@ -0,0 +1,58 @@
+package job
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"nunet/app/shared"
+)
+
+// DefaultEnvDeny keeps submitters from changing how programs are loaded on this node
+var DefaultEnvDeny = []string{"LD_*", "DYLD_*"}
+
+// EnvPolicy decides which environment variables submitters may set on this node.
+// Names are matched against shell patterns such as "APP_*"; an empty allow list
+// allows everything that isn't denied.
+type EnvPolicy struct {
+	Allow []string
+	Deny  []string
+}
+
+// Check returns a rejection naming the first variable the policy doesn't allow
+func (p EnvPolicy) Check(env map[string]string) *shared.Rejection {
+	for _, name := range sortedNames(env) {
+		if matchAny(p.Deny, name) || (len(p.Allow) > 0 && !matchAny(p.Allow, name)) {
+			return &shared.Rejection{Reason: fmt.Sprintf("environment variable %s is not allowed on this node", name)}
+		}
+	}
+	return nil
+}
+
+// jobEnv returns the job's variables, with temporary files pointed at the job's workspace.
+// Executors running jobs on the host add the node's environment underneath.
+func jobEnv(env map[string]string, workspace *Workspace) []string {
+	results := []string{"TMPDIR=" + workspace.TempDir()}
+	for _, name := range sortedNames(env) {
+		results = append(results, name+"="+env[name])
+	}
+	return results
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedNames(env map[string]string) []string {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,23 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvPolicy(t *testing.T) {
+	policy := EnvPolicy{Deny: DefaultEnvDeny}
+	assert.Nil(t, policy.Check(map[string]string{"APP_MODE": "test", "DEBUG": "1"}))
+
+	rejection := policy.Check(map[string]string{"APP_MODE": "test", "LD_PRELOAD": "/tmp/x.so"})
+	require.NotNil(t, rejection)
+	assert.Contains(t, rejection.Reason, "LD_PRELOAD")
+
+	policy = EnvPolicy{Allow: []string{"APP_*"}, Deny: []string{"APP_SECRET"}}
+	assert.Nil(t, policy.Check(map[string]string{"APP_MODE": "test"}))
+	assert.NotNil(t, policy.Check(map[string]string{"DEBUG": "1"}))
+	assert.NotNil(t, policy.Check(map[string]string{"APP_SECRET": "x"}))
+	assert.Nil(t, policy.Check(nil))
+}
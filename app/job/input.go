@@ -0,0 +1,159 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"nunet/app/blob"
+	"nunet/app/shared"
+)
+
+// stagedInputs tracks the blobs pinned for the jobs submitted from this node
+type stagedInputs struct {
+	mu   sync.Mutex
+	jobs map[string]*jobInputs
+}
+
+type jobInputs struct {
+	bytes int64
+	cids  []cid.Cid
+}
+
+func (s *stagedInputs) get(jobID string) *jobInputs {
+	if s.jobs == nil {
+		s.jobs = make(map[string]*jobInputs)
+	}
+	if s.jobs[jobID] == nil {
+		s.jobs[jobID] = &jobInputs{}
+	}
+	return s.jobs[jobID]
+}
+
+// StageInput stores a file to ship with a job submitted from this node. It stays pinned
+// until the job finishes, and can be referenced by its cid in later jobs while it's kept.
+func (j *Job) StageInput(jobID, path string, r io.Reader) (shared.File, error) {
+	if path == "" || !filepath.IsLocal(path) {
+		return shared.File{}, fmt.Errorf("%w: path %q must be relative to the working directory", shared.ErrInvalidFile, path)
+	}
+
+	var remaining int64
+	if j.MaxInputBytes > 0 {
+		j.staged.mu.Lock()
+		remaining = j.MaxInputBytes - j.staged.get(jobID).bytes
+		j.staged.mu.Unlock()
+		if remaining <= 0 {
+			return shared.File{}, fmt.Errorf("%w: a job may carry at most %d bytes", shared.ErrFilesTooLarge, j.MaxInputBytes)
+		}
+	}
+
+	c, size, err := j.Blobs.Put(r, remaining)
+	if errors.Is(err, blob.ErrTooLarge) {
+		return shared.File{}, fmt.Errorf("%w: a job may carry at most %d bytes", shared.ErrFilesTooLarge, j.MaxInputBytes)
+	}
+	if err != nil {
+		return shared.File{}, err
+	}
+
+	j.pinInput(jobID, c, size)
+	return shared.File{Path: path, Size: size, CID: c.String()}, nil
+}
+
+// ReferenceInput ships a blob already known to the network with a job submitted from this node
+func (j *Job) ReferenceInput(jobID string, file shared.File) (shared.File, error) {
+	if err := shared.ValidateFiles([]shared.File{file}); err != nil {
+		return shared.File{}, err
+	}
+	c, _ := cid.Decode(file.CID)
+
+	file.Size = 0 // only trust sizes of blobs held here
+	if blob, size, err := j.Blobs.Open(c); err == nil {
+		blob.Close()
+		file.Size = size
+	}
+	j.pinInput(jobID, c, file.Size)
+	return file, nil
+}
+
+func (j *Job) pinInput(jobID string, c cid.Cid, size int64) {
+	j.staged.mu.Lock()
+	defer j.staged.mu.Unlock()
+
+	inputs := j.staged.get(jobID)
+	inputs.bytes += size
+	inputs.cids = append(inputs.cids, c)
+	j.Blobs.Pin(c, jobID)
+}
+
+// DiscardInputs releases the files staged for a job
+func (j *Job) DiscardInputs(jobID string) {
+	j.staged.mu.Lock()
+	inputs := j.staged.jobs[jobID]
+	delete(j.staged.jobs, jobID)
+	j.staged.mu.Unlock()
+
+	if inputs == nil {
+		return
+	}
+	for _, c := range inputs.cids {
+		j.Blobs.Unpin(c, jobID)
+	}
+}
+
+// fetchInputs places the job's input files in the workspace, fetching the blobs this node
+// doesn't hold from the submitter or any other peer that has them. The blobs stay pinned
+// until the returned function is called.
+func (j *Job) fetchInputs(ctx context.Context, request shared.DeployRequest, workspace *Workspace) (func(), error) {
+	release := func() {}
+	if len(request.Inputs) == 0 {
+		return release, nil
+	}
+	if err := shared.ValidateFiles(request.Inputs); err != nil {
+		return release, err
+	}
+
+	var total int64
+	for _, input := range request.Inputs {
+		total += input.Size
+	}
+	if workspace.Quota > 0 && total > workspace.Quota {
+		return release, fmt.Errorf("%w: inputs take %d bytes", ErrQuotaExceeded, total)
+	}
+
+	var providers []peer.ID
+	if source, err := peer.Decode(request.SourcePeerID); err == nil {
+		providers = append(providers, source)
+	}
+
+	var pinned []cid.Cid
+	release = func() {
+		for _, c := range pinned {
+			j.Blobs.Unpin(c, request.JobID)
+		}
+	}
+	for _, input := range request.Inputs {
+		c, _ := cid.Decode(input.CID)
+		j.Blobs.Pin(c, request.JobID)
+		pinned = append(pinned, c)
+
+		if err := j.Exchange.Fetch(ctx, c, providers...); err != nil {
+			return release, fmt.Errorf("error fetching input %s: %w", input.Path, err)
+		}
+
+		path := filepath.Join(workspace.Dir, input.Path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return release, fmt.Errorf("error creating directory: %w", err)
+		}
+		if err := j.Blobs.CopyTo(c, path); err != nil {
+			return release, fmt.Errorf("error placing input %s: %w", input.Path, err)
+		}
+	}
+	return release, nil
+}
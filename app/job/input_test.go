@@ -0,0 +1,71 @@
+package job
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nunet/app/blob"
+	"nunet/app/shared"
+)
+
+func TestStageInput(t *testing.T) {
+	_, h := newTestHosts(t)
+	j := newTestJob(t, h)
+	j.MaxInputBytes = 10
+
+	input, err := j.StageInput("job-1", "data/in.txt", strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, shared.File{
+		Path: "data/in.txt",
+		Size: 5,
+		CID:  "bafkreibm6jg3ux5qumhcn2b3flc3tyu6dmlb4xa7u5bf44yegnrjhc4yeq",
+	}, input)
+
+	c, err := cid.Decode(input.CID)
+	require.NoError(t, err)
+	assert.True(t, j.Blobs.Pinned(c))
+
+	// the limit covers every file of the job
+	_, err = j.StageInput("job-1", "more.txt", strings.NewReader("world!"))
+	assert.ErrorIs(t, err, shared.ErrFilesTooLarge)
+	_, err = j.StageInput("job-2", "more.txt", strings.NewReader("world!"))
+	assert.NoError(t, err)
+
+	_, err = j.StageInput("job-3", "../escape.txt", strings.NewReader("x"))
+	assert.ErrorIs(t, err, shared.ErrInvalidFile)
+
+	// referenced blobs only get a size when held here
+	referenced, err := j.ReferenceInput("job-3", shared.File{Path: "in.txt", CID: input.CID, Size: 1})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), referenced.Size)
+	unknown := blob.Sum([]byte("elsewhere")).String()
+	referenced, err = j.ReferenceInput("job-3", shared.File{Path: "in.txt", CID: unknown, Size: 1})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), referenced.Size)
+	_, err = j.ReferenceInput("job-3", shared.File{Path: "in.txt", CID: "abc"})
+	assert.Error(t, err)
+
+	j.DiscardInputs("job-1")
+	assert.True(t, j.Blobs.Pinned(c)) // still used by job-3
+	j.DiscardInputs("job-3")
+	assert.False(t, j.Blobs.Pinned(c))
+}
+
+func TestValidateFiles(t *testing.T) {
+	c := blob.Sum([]byte("contents")).String()
+	assert.NoError(t, shared.ValidateFiles([]shared.File{{Path: "a.txt", CID: c}, {Path: "b/a.txt", CID: c}}))
+	assert.Error(t, shared.ValidateFiles([]shared.File{{Path: "a.txt", CID: c}, {Path: "./a.txt", CID: c}}))
+	assert.Error(t, shared.ValidateFiles([]shared.File{{Path: "/etc/passwd", CID: c}}))
+	assert.Error(t, shared.ValidateFiles([]shared.File{{Path: "a.txt", CID: "abc"}}))
+}
+
+func TestValidateArtifactPatterns(t *testing.T) {
+	assert.NoError(t, shared.ValidateArtifactPatterns([]string{"out/*.csv", "result.json"}))
+	assert.Error(t, shared.ValidateArtifactPatterns([]string{"../*.csv"}))
+	assert.Error(t, shared.ValidateArtifactPatterns([]string{"/tmp/*"}))
+	assert.Error(t, shared.ValidateArtifactPatterns([]string{"out/[a"}))
+}
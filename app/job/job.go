@@ -1,36 +1,242 @@
 package job
 
 import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
+
+	"nunet/app/blob"
+	"nunet/app/executor"
+	"nunet/app/shared"
+	"nunet/pkg"
 )
 
+// Job dispatches jobs to peers over the job protocol and runs the ones sent to this node.
+// The deployment topic is only used to find the peers taking part in the network.
 type Job struct {
-	Host                    host.Host
-	DeploymentTopic         *pubsub.Topic
-	DeploymentSub           *pubsub.Subscription
-	DeploymentResponseTopic *pubsub.Topic
-	DeploymentResponseSub   *pubsub.Subscription
+	Host            host.Host
+	DeploymentTopic *pubsub.Topic
+	DeploymentSub   *pubsub.Subscription
+	Registry        *Registry
+	Logs            *Logs
+	Scheduler       Scheduler
+	Admission       *Admission
+	Pool            *Pool
+	PeerCompute     ComputeLookup  // optional, resources advertised by peers
+	PeerExecutors   ExecutorLookup // optional, runtimes advertised by peers
+	Executors       *executor.Set  // runtimes jobs sent to this node can run in
+	EnvPolicy       EnvPolicy      // environment variables submitters may set
+	Policy          *Policy        // optional, which programs peers may run here
+	Workspaces      *Workspaces    // private directories jobs run in
+	Blobs           *blob.Store    // input files and artifacts, stored by cid
+	Exchange        *blob.Exchange // fetches the blobs this node doesn't hold from peers
+
+	MaxRetries       int           // how many other peers to try when a job can't be run
+	ResponseTimeout  time.Duration // how long to wait for a result when the job sets no max duration
+	MaxOutputBytes   int           // output of a job kept beyond this is dropped, pkg.DefaultMaxOutputBytes if unset
+	MaxInputBytes    int64         // input files a job submitted from here may carry, unlimited if 0
+	MaxArtifactBytes int64         // artifacts a job run here may bring back, unlimited if 0
+	JobRetention     time.Duration // how long a finished job and its logs are kept, forever if 0
+	MaxFinishedJobs  int           // finished jobs kept before the oldest are forgotten, unlimited if 0
+
+	staged stagedInputs // blobs pinned for jobs submitted from here
+
+	running   sync.Map // job id -> context.CancelFunc of jobs executed here
+	streams   sync.Map // job id -> *jobStream of jobs submitted from here, while awaiting their result
+	cancelled sync.Map // job id -> struct{} of submitted jobs the user asked to cancel
 }
 
+// responseGracePeriod is added to a job's max duration before the submitter gives up on it
+const responseGracePeriod = 30 * time.Second
+
+// cancelSendTimeout bounds how long a submitter giving up on a job tries to tell its peer
+const cancelSendTimeout = 5 * time.Second
+
 // New creates a new Job instance
 func New(
 	h host.Host,
 	deploymentTopic *pubsub.Topic,
 	deploymentSub *pubsub.Subscription,
-	deploymentResponseTopic *pubsub.Topic,
-	deploymentResponseSub *pubsub.Subscription,
+	scheduler Scheduler,
+	admission *Admission,
+	pool *Pool,
+	executors *executor.Set,
+	workspaces *Workspaces,
+	blobs *blob.Store,
+	exchange *blob.Exchange,
 ) *Job {
 	return &Job{
-		Host:                    h,
-		DeploymentTopic:         deploymentTopic,
-		DeploymentSub:           deploymentSub,
-		DeploymentResponseTopic: deploymentResponseTopic,
-		DeploymentResponseSub:   deploymentResponseSub,
+		Host:            h,
+		DeploymentTopic: deploymentTopic,
+		DeploymentSub:   deploymentSub,
+		Registry:        NewRegistry(),
+		Logs:            NewLogs(),
+		Scheduler:       scheduler,
+		Admission:       admission,
+		Pool:            pool,
+		Executors:       executors,
+		Workspaces:      workspaces,
+		Blobs:           blobs,
+		Exchange:        exchange,
 	}
 }
 
+// ListPeers returns the peers that joined the deployment topic
 func (j *Job) ListPeers() []peer.ID {
 	return j.DeploymentTopic.ListPeers()
 }
+
+// SelectPeer asks the scheduler for the connected peer best suited to run a job with the given
+// requirements, among the ones supporting its runtime
+func (j *Job) SelectPeer(runtime string, resources shared.Resources) (peer.ID, error) {
+	return j.selectPeer(runtime, resources, nil)
+}
+
+// selectPeer picks the best connected peer for the job, leaving out the excluded ones
+func (j *Job) selectPeer(runtime string, resources shared.Resources, excluded map[peer.ID]bool) (peer.ID, error) {
+	var candidates []Candidate
+	peers := j.ListPeers()
+	for _, id := range peers {
+		if excluded[id] {
+			continue
+		}
+		candidate := Candidate{PeerID: id}
+		if j.PeerCompute != nil {
+			candidate.Compute = j.PeerCompute(id)
+		}
+		if j.PeerExecutors != nil {
+			candidate.Executors = j.PeerExecutors(id)
+		}
+		if candidate.supports(runtime) {
+			candidates = append(candidates, candidate)
+		}
+	}
+	if len(candidates) == 0 && len(peers) > len(excluded) {
+		return "", fmt.Errorf("%w: no peer supports runtime %q", ErrNoCandidate, runtime)
+	}
+	return j.Scheduler.Select(candidates, resources)
+}
+
+// NodeCompute returns the compute of this node, whether jobs reserved it or not
+func (j *Job) NodeCompute() (*pkg.AvailableCompute, error) {
+	return j.Admission.Total()
+}
+
+// AvailableCompute returns the compute this node can still offer to new jobs
+func (j *Job) AvailableCompute() (*pkg.AvailableCompute, error) {
+	return j.Admission.Available()
+}
+
+// RunningJobs returns the number of jobs this node is currently executing
+func (j *Job) RunningJobs() int {
+	running, _ := j.Pool.Stats()
+	return running
+}
+
+// QueuedJobs returns the number of jobs waiting for a free slot on this node
+func (j *Job) QueuedJobs() int {
+	_, queued := j.Pool.Stats()
+	return queued
+}
+
+// GetJob returns the job with the given id from the registry
+func (j *Job) GetJob(id string) (shared.JobRecord, bool) {
+	return j.Registry.Get(id)
+}
+
+// ListJobs returns the jobs matching the filter and the total number of matches
+func (j *Job) ListJobs(filter shared.JobFilter) ([]shared.JobRecord, int) {
+	return j.Registry.Find(filter)
+}
+
+// WaitForJob blocks until the job finishes or the context is done
+func (j *Job) WaitForJob(ctx context.Context, id string) (shared.JobRecord, error) {
+	return j.Registry.Wait(ctx, id)
+}
+
+// listAddresses returns this node's p2p addresses
+func (j *Job) listAddresses() []string {
+	addrs, err := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{
+		ID:    j.Host.ID(),
+		Addrs: j.Host.Addrs(),
+	})
+	if err != nil {
+		return nil
+	}
+
+	var results []string
+	for _, addr := range addrs {
+		results = append(results, addr.String())
+	}
+	return results
+}
+
+// CancelJob stops a job. Jobs submitted from this node are cancelled on the peer running them;
+// the final response reports a cancelled status.
+func (j *Job) CancelJob(id string) error {
+	record, ok := j.Registry.Get(id)
+	if !ok {
+		return shared.ErrJobNotFound
+	}
+	if record.Status.IsTerminal() {
+		return shared.ErrJobFinished
+	}
+
+	if record.Role == shared.JobRoleExecutor {
+		if cancel, ok := j.running.Load(id); ok {
+			cancel.(context.CancelFunc)()
+		}
+		return nil
+	}
+
+	j.cancelled.Store(id, struct{}{})
+	if stream, ok := j.streams.Load(id); ok {
+		return stream.(*jobStream).Send(Message{Type: MessageCancel})
+	}
+	return nil // between attempts; followJob stops before the next one
+}
+
+// ReadLogs returns the job's output from seq onwards, whether the job is done
+// producing output, and a channel closed when there is more to read
+func (j *Job) ReadLogs(id string, seq int) ([]shared.LogEntry, bool, <-chan struct{}, error) {
+	record, ok := j.Registry.Get(id)
+	if !ok {
+		return nil, false, nil, shared.ErrJobNotFound
+	}
+	entries, done, more := j.Logs.Read(id, seq)
+	return entries, done || record.Status.IsTerminal(), more, nil
+}
+
+// ForgetFinished drops the finished jobs, and their logs, kept longer than JobRetention or
+// beyond the newest MaxFinishedJobs
+func (j *Job) ForgetFinished() {
+	for _, id := range j.Registry.Prune(j.JobRetention, j.MaxFinishedJobs) {
+		j.Logs.Remove(id)
+	}
+}
+
+// CollectFinished runs ForgetFinished every interval until ctx is done
+func (j *Job) CollectFinished(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			j.ForgetFinished()
+		}
+	}
+}
+
+// cancelRequested reports whether the user asked to cancel a submitted job
+func (j *Job) cancelRequested(id string) bool {
+	_, ok := j.cancelled.Load(id)
+	return ok
+}
@@ -0,0 +1,116 @@
+package job
+
+import (
+	"sync"
+	"time"
+
+	"nunet/app/shared"
+)
+
+// maxLogEntries bounds the output kept per job; later entries are dropped
+const maxLogEntries = 10000
+
+type jobLog struct {
+	entries []shared.LogEntry
+	closed  bool
+	changed chan struct{} // closed and replaced whenever entries are added or the log is closed
+}
+
+// Logs keeps the output of jobs as it is produced so it can be followed live
+type Logs struct {
+	mu   sync.Mutex
+	logs map[string]*jobLog
+}
+
+// NewLogs creates an empty log store
+func NewLogs() *Logs {
+	return &Logs{
+		logs: make(map[string]*jobLog),
+	}
+}
+
+// get returns the log of the job, creating it if needed. Callers must hold the lock.
+func (l *Logs) get(id string) *jobLog {
+	log, ok := l.logs[id]
+	if !ok {
+		log = &jobLog{changed: make(chan struct{})}
+		l.logs[id] = log
+	}
+	return log
+}
+
+// Append adds output to the job's log and wakes up its followers.
+// It returns false once the log is closed or full.
+func (l *Logs) Append(id string, stream string, text string) (shared.LogEntry, bool) {
+	entry := shared.LogEntry{
+		Stream: stream,
+		Text:   text,
+		Time:   time.Now().UTC(),
+	}
+	return entry, l.AppendEntry(id, &entry)
+}
+
+// AppendEntry adds an entry, such as one received from the peer running the job,
+// numbering it after the entries already in the log
+func (l *Logs) AppendEntry(id string, entry *shared.LogEntry) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	log := l.get(id)
+	if log.closed || len(log.entries) >= maxLogEntries {
+		return false
+	}
+
+	entry.Seq = len(log.entries)
+	log.entries = append(log.entries, *entry)
+	close(log.changed)
+	log.changed = make(chan struct{})
+	return true
+}
+
+// Close marks the job's log as complete
+func (l *Logs) Close(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	log := l.get(id)
+	if log.closed {
+		return
+	}
+	log.closed = true
+	close(log.changed)
+}
+
+// Remove forgets the job's log, waking up its followers
+func (l *Logs) Remove(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	log, ok := l.logs[id]
+	if !ok {
+		return
+	}
+	if !log.closed {
+		close(log.changed)
+	}
+	delete(l.logs, id)
+}
+
+// Read returns the entries from seq onwards, whether the log is complete, and a
+// channel that is closed as soon as there is more to read
+func (l *Logs) Read(id string, seq int) ([]shared.LogEntry, bool, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	log := l.get(id)
+	if seq < 0 {
+		seq = 0
+	}
+	if seq > len(log.entries) {
+		seq = len(log.entries)
+	}
+
+	entries := make([]shared.LogEntry, len(log.entries)-seq)
+	copy(entries, log.entries[seq:])
+	return entries, log.closed, log.changed
+}
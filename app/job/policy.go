@@ -0,0 +1,463 @@
+package job
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+
+	"nunet/app/executor"
+	"nunet/app/shared"
+)
+
+// policyDenied starts the reason of every rejection made by a Policy
+const policyDenied = "denied by policy"
+
+// Policy decides which programs this node runs, and for whom. A job runs if a rule lets its
+// submitter run its program with its arguments, and the submitter's jobs stay within what
+// the rule allows them to use at once. A nil policy lets anyone run anything.
+type Policy struct {
+	Groups map[string][]string `json:"groups"` // group name -> peer ids
+	Rules  []PolicyRule        `json:"rules"`  // tried in order, the first one matching the job applies
+
+	mu       sync.Mutex
+	inUse    map[string]shared.Resources // submitter -> resources of its jobs running here
+	admitted map[string]policyGrant      // job id -> what it was admitted with
+	digests  map[string]programDigest    // program path -> sha256 of its file
+}
+
+// PolicyRule lets peers run programs
+type PolicyRule struct {
+	Name         string           `json:"name"`          // shown in logs
+	Peers        []string         `json:"peers"`         // peer ids and @groups the rule is for, "*" for every peer
+	Programs     []ProgramRule    `json:"programs"`      // programs the peers may run, any if empty
+	MaxResources shared.Resources `json:"max_resources"` // what the jobs of each of the peers may use at once, unlimited where 0
+}
+
+// ProgramRule allows a program, by path or shell pattern, by the sha256 of its file, or both
+type ProgramRule struct {
+	Runtime string   `json:"runtime"`  // runtime the program runs in, process if empty
+	Path    string   `json:"path"`     // the program or a pattern such as /usr/bin/*; for process jobs the file it resolves to also matches
+	SHA256  string   `json:"sha256"`   // hex digest of the program's file, wasm module, or container image
+	Args    []string `json:"args"`     // regular expressions, each argument must match one of them in full; any arguments if empty
+	MaxArgs *int     `json:"max_args"` // arguments the program may be given, unlimited if unset
+
+	args []*regexp.Regexp
+}
+
+// policyGrant is what a job was admitted with
+type policyGrant struct {
+	submitter string
+	resources shared.Resources
+}
+
+// programDigest is the sha256 of a program's file, as long as the file isn't changed
+type programDigest struct {
+	size    int64
+	modTime time.Time
+	sha256  string
+}
+
+// LoadPolicy reads a policy from a JSON file
+func LoadPolicy(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading policy: %w", err)
+	}
+	policy, err := ParsePolicy(data)
+	if err != nil {
+		return nil, fmt.Errorf("error reading policy %s: %w", file, err)
+	}
+	return policy, nil
+}
+
+// ParsePolicy reads a policy from JSON, refusing fields it doesn't know so typos don't go unnoticed
+func ParsePolicy(data []byte) (*Policy, error) {
+	policy := &Policy{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(policy); err != nil {
+		return nil, err
+	}
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	policy.inUse = make(map[string]shared.Resources)
+	policy.admitted = make(map[string]policyGrant)
+	policy.digests = make(map[string]programDigest)
+	return policy, nil
+}
+
+// validate checks the policy and prepares its rules
+func (p *Policy) validate() error {
+	for name, members := range p.Groups {
+		for i, member := range members {
+			id, err := peer.Decode(member)
+			if err != nil {
+				return fmt.Errorf("group %s: invalid peer id %q", name, member)
+			}
+			members[i] = id.String()
+		}
+	}
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if len(rule.Peers) == 0 {
+			return fmt.Errorf("%s: no peers, use \"*\" for every peer", rule.Name)
+		}
+		for j, entry := range rule.Peers {
+			if group, ok := strings.CutPrefix(entry, "@"); ok {
+				if _, ok := p.Groups[group]; !ok {
+					return fmt.Errorf("%s: unknown group %s", rule.Name, group)
+				}
+			} else if entry != "*" {
+				id, err := peer.Decode(entry)
+				if err != nil {
+					return fmt.Errorf("%s: invalid peer id %q", rule.Name, entry)
+				}
+				rule.Peers[j] = id.String()
+			}
+		}
+		if err := rule.MaxResources.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", rule.Name, err)
+		}
+
+		for j := range rule.Programs {
+			program := &rule.Programs[j]
+			if program.Path == "" && program.SHA256 == "" {
+				return fmt.Errorf("%s: program %d names neither a path nor a sha256", rule.Name, j+1)
+			}
+			if _, err := path.Match(program.Path, ""); err != nil {
+				return fmt.Errorf("%s: invalid program pattern %q", rule.Name, program.Path)
+			}
+			if program.SHA256 != "" {
+				if digest, err := hex.DecodeString(program.SHA256); err != nil || len(digest) != sha256.Size {
+					return fmt.Errorf("%s: invalid sha256 %q", rule.Name, program.SHA256)
+				}
+				program.SHA256 = strings.ToLower(program.SHA256)
+			}
+			for _, pattern := range program.Args {
+				arg, err := regexp.Compile(`^(?:` + pattern + `)$`)
+				if err != nil {
+					return fmt.Errorf("%s: invalid argument pattern %q: %w", rule.Name, pattern, err)
+				}
+				program.args = append(program.args, arg)
+			}
+		}
+	}
+	return nil
+}
+
+// Admit checks the job against the policy and holds the resources it asked for against its
+// submitter's allowance until Release. Resources the job leaves out are set to the rule's limits,
+// which then bound it like declared ones. The program of process jobs is replaced by the file the
+// policy checked, so the job's own PATH can't swap it for another.
+func (p *Policy) Admit(jobID string, request *shared.DeployRequest) *shared.Rejection {
+	if p == nil {
+		return nil
+	}
+
+	resolved := resolveProgram(request)
+	rule, rejection := p.match(request, resolved)
+	if rejection != nil {
+		return rejection
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	inUse := p.inUse[request.SourcePeerID]
+	limit := rule.MaxResources
+	// A job leaving out a resource the rule limits is given the whole limit, so it's held to it
+	// like any other job and can't run unbounded
+	resources := request.Resources
+	if resources.CPU == 0 {
+		resources.CPU = limit.CPU
+	}
+	if resources.RAM == 0 {
+		resources.RAM = limit.RAM
+	}
+	if resources.Disk == 0 {
+		resources.Disk = limit.Disk
+	}
+	if resources.MaxDuration == 0 {
+		resources.MaxDuration = limit.MaxDuration
+	}
+	checks := []struct {
+		resource  string
+		requested float64
+		used      float64
+		limit     float64
+	}{
+		{"cpu", float64(resources.CPU), float64(inUse.CPU), float64(limit.CPU)},
+		{"ram", resources.RAM, inUse.RAM, limit.RAM},
+		{"disk", resources.Disk, inUse.Disk, limit.Disk},
+		{"max_duration", float64(resources.MaxDuration), 0, float64(limit.MaxDuration)},
+	}
+	for _, check := range checks {
+		if check.limit > 0 && check.used+check.requested > check.limit {
+			return &shared.Rejection{
+				Reason:         policyDenied + ": exceeds submitter limit of " + rule.Name,
+				Resource:       check.resource,
+				Requested:      check.requested,
+				Available:      check.limit - check.used,
+				DeniedByPolicy: true,
+			}
+		}
+	}
+
+	p.inUse[request.SourcePeerID] = shared.Resources{
+		CPU:  inUse.CPU + resources.CPU,
+		RAM:  inUse.RAM + resources.RAM,
+		Disk: inUse.Disk + resources.Disk,
+	}
+	p.admitted[jobID] = policyGrant{submitter: request.SourcePeerID, resources: resources}
+	request.Resources = resources
+	if resolved != "" {
+		request.Program = resolved
+	}
+	return nil
+}
+
+// Release gives back what the job held against its submitter's allowance
+func (p *Policy) Release(jobID string) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	grant, ok := p.admitted[jobID]
+	if !ok {
+		return
+	}
+	delete(p.admitted, jobID)
+	inUse := p.inUse[grant.submitter]
+	inUse.CPU -= grant.resources.CPU
+	inUse.RAM -= grant.resources.RAM
+	inUse.Disk -= grant.resources.Disk
+	if inUse == (shared.Resources{}) {
+		delete(p.inUse, grant.submitter)
+	} else {
+		p.inUse[grant.submitter] = inUse
+	}
+}
+
+// match returns the first rule allowing the job, or a rejection explaining the closest miss
+func (p *Policy) match(request *shared.DeployRequest, resolved string) (*PolicyRule, *shared.Rejection) {
+	denial := fmt.Sprintf("peer %s may not submit jobs to this node", request.SourcePeerID)
+	forPeer := false
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if !p.hasPeer(rule, request.SourcePeerID) {
+			continue
+		}
+		if !forPeer {
+			forPeer = true
+			denial = fmt.Sprintf("program %q is not allowed", request.Program)
+		}
+		if len(rule.Programs) == 0 {
+			return rule, nil
+		}
+		for j := range rule.Programs {
+			program := &rule.Programs[j]
+			if !p.allowsProgram(program, request, resolved) {
+				continue
+			}
+			if reason := program.checkArgs(request.Arguments); reason != "" {
+				denial = reason
+				continue
+			}
+			return rule, nil
+		}
+	}
+	return nil, &shared.Rejection{Reason: policyDenied + ": " + denial, DeniedByPolicy: true}
+}
+
+// hasPeer reports whether the rule is for the peer
+func (p *Policy) hasPeer(rule *PolicyRule, id string) bool {
+	for _, entry := range rule.Peers {
+		group, isGroup := strings.CutPrefix(entry, "@")
+		switch {
+		case entry == "*" || entry == id:
+			return true
+		case isGroup:
+			for _, member := range p.Groups[group] {
+				if member == id {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// allowsProgram reports whether the program rule matches the job's runtime and program
+func (p *Policy) allowsProgram(program *ProgramRule, request *shared.DeployRequest, resolved string) bool {
+	runtime := request.Runtime
+	if runtime == "" {
+		runtime = executor.RuntimeProcess
+	}
+	wanted := program.Runtime
+	if wanted == "" {
+		wanted = executor.RuntimeProcess
+	}
+	if runtime != wanted {
+		return false
+	}
+
+	if program.Path != "" {
+		matched, _ := path.Match(program.Path, request.Program)
+		if !matched && resolved != "" {
+			matched, _ = path.Match(program.Path, resolved)
+		}
+		if !matched {
+			return false
+		}
+	}
+	if program.SHA256 != "" && p.programSHA256(request, resolved) != program.SHA256 {
+		return false
+	}
+	return true
+}
+
+// programSHA256 returns the hex sha256 of what the job runs, or an empty string if it can't be
+// known before the job runs: the file a process job's program resolves to, the wasm module
+// shipped as one of its inputs, whose cid is its digest and is checked when it's fetched, or
+// the digest a container image is pinned to
+func (p *Policy) programSHA256(request *shared.DeployRequest, resolved string) string {
+	switch request.Runtime {
+	case "", executor.RuntimeProcess:
+		if resolved == "" {
+			return ""
+		}
+		digest, err := p.digest(resolved)
+		if err != nil {
+			return ""
+		}
+		return digest
+	case executor.RuntimeWasm:
+		for _, input := range request.Inputs {
+			if filepath.Clean(input.Path) != filepath.Clean(request.Program) {
+				continue
+			}
+			c, err := cid.Decode(input.CID)
+			if err != nil || c.Prefix().Codec != cid.Raw {
+				return ""
+			}
+			decoded, err := multihash.Decode(c.Hash())
+			if err != nil || decoded.Code != multihash.SHA2_256 {
+				return ""
+			}
+			return hex.EncodeToString(decoded.Digest)
+		}
+		return ""
+	case executor.RuntimeContainer:
+		_, digest, _ := strings.Cut(request.Program, "@sha256:")
+		return strings.ToLower(digest)
+	}
+	return ""
+}
+
+// checkArgs explains why the arguments aren't allowed, or returns an empty string if they are
+func (r *ProgramRule) checkArgs(args []string) string {
+	if r.MaxArgs != nil && len(args) > *r.MaxArgs {
+		return fmt.Sprintf("%s takes at most %d arguments", r.describe(), *r.MaxArgs)
+	}
+	if len(r.args) == 0 {
+		return ""
+	}
+	for _, arg := range args {
+		allowed := false
+		for _, pattern := range r.args {
+			if pattern.MatchString(arg) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Sprintf("argument %q is not allowed for %s", arg, r.describe())
+		}
+	}
+	return ""
+}
+
+// describe names the program the rule is about
+func (r *ProgramRule) describe() string {
+	if r.Path != "" {
+		return r.Path
+	}
+	return "sha256 " + r.SHA256
+}
+
+// digest returns the sha256 of the file, hashing it again only once it changed
+func (p *Policy) digest(file string) (string, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	cached, ok := p.digests[file]
+	p.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.sha256, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+
+	p.mu.Lock()
+	p.digests[file] = programDigest{size: info.Size(), modTime: info.ModTime(), sha256: digest}
+	p.mu.Unlock()
+	return digest, nil
+}
+
+// resolveProgram returns the file a process job's program is found at on this node, or an
+// empty string for other runtimes and programs inside the job's workspace
+func resolveProgram(request *shared.DeployRequest) string {
+	if request.Runtime != "" && request.Runtime != executor.RuntimeProcess {
+		return ""
+	}
+	switch {
+	case filepath.IsAbs(request.Program):
+		return filepath.Clean(request.Program)
+	case strings.Contains(request.Program, "/"):
+		return "" // relative to the job's workspace, which doesn't exist yet
+	}
+	file, err := exec.LookPath(request.Program)
+	if err != nil {
+		return ""
+	}
+	file, err = filepath.Abs(file)
+	if err != nil {
+		return ""
+	}
+	return file
+}
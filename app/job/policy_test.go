@@ -0,0 +1,196 @@
+package job
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nunet/app/blob"
+	"nunet/app/shared"
+)
+
+// newTestPeerID returns the id of a new peer
+func newTestPeerID(t *testing.T) string {
+	_, key, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	id, err := peer.IDFromPublicKey(key)
+	require.NoError(t, err)
+	return id.String()
+}
+
+func TestParsePolicy(t *testing.T) {
+	id := newTestPeerID(t)
+	invalid := map[string]string{
+		`{"rules":[{"peers":["*"],"programs":[{"path":"echo"}]}],"extra":1}`:           "unknown field",
+		`{"rules":[{"programs":[{"path":"echo"}]}]}`:                                   "no peers",
+		`{"rules":[{"peers":["@lab"]}]}`:                                               "unknown group lab",
+		`{"rules":[{"peers":["not-a-peer"]}]}`:                                         "invalid peer id",
+		`{"rules":[{"peers":["*"],"programs":[{"args":["-v"]}]}]}`:                     "neither a path nor a sha256",
+		`{"rules":[{"peers":["*"],"programs":[{"path":"["}]}]}`:                        "invalid program pattern",
+		`{"rules":[{"peers":["*"],"programs":[{"sha256":"abc"}]}]}`:                    "invalid sha256",
+		`{"rules":[{"peers":["*"],"programs":[{"path":"echo","args":["("]}]}]}`:        "invalid argument pattern",
+		`{"rules":[{"peers":["*"],"max_resources":{"cpu":-1}}]}`:                       "cpu must not be negative",
+		fmt.Sprintf(`{"groups":{"lab":[%q,"nope"]},"rules":[{"peers":["@lab"]}]}`, id): "invalid peer id",
+	}
+	for data, message := range invalid {
+		_, err := ParsePolicy([]byte(data))
+		assert.ErrorContains(t, err, message, data)
+	}
+
+	policy, err := ParsePolicy([]byte(fmt.Sprintf(`{"groups":{"lab":[%q]},"rules":[{"peers":["@lab"]}]}`, id)))
+	require.NoError(t, err)
+	assert.Equal(t, "rule 1", policy.Rules[0].Name)
+}
+
+func TestPolicyAdmit(t *testing.T) {
+	lab, other := newTestPeerID(t), newTestPeerID(t)
+	program := filepath.Join(t.TempDir(), "render")
+	require.NoError(t, os.WriteFile(program, []byte("#!/bin/sh\n"), 0o755))
+	digest := sha256.Sum256([]byte("#!/bin/sh\n"))
+
+	policy, err := ParsePolicy([]byte(fmt.Sprintf(`{
+		"groups": {"lab": [%q]},
+		"rules": [
+			{"name": "lab", "peers": ["@lab"], "programs": [
+				{"path": "echo", "args": ["-n", "[a-z]+"], "max_args": 2},
+				{"path": "/opt/tools/*"},
+				{"sha256": %[2]q},
+				{"runtime": "container", "path": "alpine:*"},
+				{"runtime": "wasm", "sha256": %[2]q},
+				{"runtime": "container", "sha256": %[2]q}
+			]},
+			{"name": "anyone", "peers": ["*"], "programs": [{"path": "true", "max_args": 0}]}
+		]
+	}`, lab, hex.EncodeToString(digest[:]))))
+	require.NoError(t, err)
+
+	admit := func(request shared.DeployRequest) (shared.DeployRequest, *shared.Rejection) {
+		rejection := policy.Admit("job", &request)
+		policy.Release("job")
+		return request, rejection
+	}
+
+	request, rejection := admit(shared.DeployRequest{SourcePeerID: lab, Program: "echo", Arguments: []string{"-n", "hello"}})
+	require.Nil(t, rejection)
+	assert.True(t, filepath.IsAbs(request.Program), "the program is pinned to the file that was checked")
+
+	_, rejection = admit(shared.DeployRequest{SourcePeerID: lab, Program: program})
+	assert.Nil(t, rejection, "allowed by sha256")
+	_, rejection = admit(shared.DeployRequest{SourcePeerID: lab, Program: "/opt/tools/convert"})
+	assert.Nil(t, rejection)
+	_, rejection = admit(shared.DeployRequest{SourcePeerID: lab, Runtime: "container", Program: "alpine:3"})
+	assert.Nil(t, rejection)
+	_, rejection = admit(shared.DeployRequest{SourcePeerID: other, Program: "true"})
+	assert.Nil(t, rejection)
+
+	// wasm modules match by the cid they're shipped with, container images by the digest they're pinned to
+	module := shared.File{Path: "bin/render.wasm", CID: blob.Sum([]byte("#!/bin/sh\n")).String()}
+	otherModule := shared.File{Path: "bin/render.wasm", CID: blob.Sum([]byte("(module)")).String()}
+	_, rejection = admit(shared.DeployRequest{SourcePeerID: lab, Runtime: "wasm", Program: "bin/render.wasm", Inputs: []shared.File{module}})
+	assert.Nil(t, rejection, "allowed by sha256")
+	_, rejection = admit(shared.DeployRequest{SourcePeerID: lab, Runtime: "container", Program: "registry.example/render@sha256:" + hex.EncodeToString(digest[:])})
+	assert.Nil(t, rejection, "allowed by sha256")
+	for _, request := range []shared.DeployRequest{
+		{SourcePeerID: lab, Runtime: "wasm", Program: "bin/render.wasm", Inputs: []shared.File{otherModule}},
+		{SourcePeerID: lab, Runtime: "wasm", Program: "bin/other.wasm", Inputs: []shared.File{module}},
+		{SourcePeerID: lab, Runtime: "container", Program: "registry.example/render:latest"},
+		{SourcePeerID: lab, Runtime: "container", Program: "registry.example/render@sha256:" + strings.Repeat("0", 64)},
+	} {
+		_, rejection = admit(request)
+		assert.NotNil(t, rejection, request.Program)
+	}
+
+	denied := map[string]shared.DeployRequest{
+		"argument \"HELLO\" is not allowed for echo": {SourcePeerID: lab, Program: "echo", Arguments: []string{"HELLO"}},
+		"echo takes at most 2 arguments":             {SourcePeerID: lab, Program: "echo", Arguments: []string{"a", "b", "c"}},
+		"program \"rm\" is not allowed":              {SourcePeerID: lab, Program: "rm"},
+		"program \"/opt/tools/../bin/sh\"":           {SourcePeerID: lab, Program: "/opt/tools/../bin/sh"},
+		"program \"alpine:3\" is not allowed":        {SourcePeerID: lab, Program: "alpine:3"},
+		"program \"echo\" is not allowed":            {SourcePeerID: other, Program: "echo"},
+		"true takes at most 0 arguments":             {SourcePeerID: other, Program: "true", Arguments: []string{"x"}},
+	}
+	for reason, request := range denied {
+		_, rejection := admit(request)
+		require.NotNil(t, rejection, reason)
+		assert.True(t, rejection.DeniedByPolicy)
+		assert.Contains(t, rejection.Reason, "denied by policy: "+reason)
+	}
+
+	// A changed program no longer matches its digest
+	require.NoError(t, os.WriteFile(program, []byte("#!/bin/sh\nrm -rf /\n"), 0o755))
+	_, rejection = admit(shared.DeployRequest{SourcePeerID: lab, Program: program})
+	assert.NotNil(t, rejection)
+
+	var nilPolicy *Policy
+	assert.Nil(t, nilPolicy.Admit("job", &shared.DeployRequest{Program: "rm"}))
+	nilPolicy.Release("job")
+}
+
+func TestPolicyPeers(t *testing.T) {
+	trusted, stranger := newTestPeerID(t), newTestPeerID(t)
+	policy, err := ParsePolicy([]byte(fmt.Sprintf(`{"rules":[{"peers":[%q]}]}`, trusted)))
+	require.NoError(t, err)
+
+	assert.Nil(t, policy.Admit("job-1", &shared.DeployRequest{SourcePeerID: trusted, Program: "anything"}))
+	rejection := policy.Admit("job-2", &shared.DeployRequest{SourcePeerID: stranger, Program: "anything"})
+	require.NotNil(t, rejection)
+	assert.Equal(t, fmt.Sprintf("denied by policy: peer %s may not submit jobs to this node", stranger), rejection.Error())
+}
+
+func TestPolicyMaxResources(t *testing.T) {
+	submitter, other := newTestPeerID(t), newTestPeerID(t)
+	policy, err := ParsePolicy([]byte(`{"rules":[{"name":"shared","peers":["*"],"max_resources":{"cpu":4,"ram":8,"max_duration":60}}]}`))
+	require.NoError(t, err)
+
+	job := func(submitter string, cpu int, ram float64) *shared.DeployRequest {
+		return &shared.DeployRequest{SourcePeerID: submitter, Program: "sleep", Resources: shared.Resources{CPU: cpu, RAM: ram}}
+	}
+	require.Nil(t, policy.Admit("job-1", job(submitter, 3, 2)))
+
+	rejection := policy.Admit("job-2", job(submitter, 2, 2))
+	require.NotNil(t, rejection)
+	assert.True(t, rejection.DeniedByPolicy)
+	assert.Equal(t, "cpu", rejection.Resource)
+	assert.Equal(t, float64(1), rejection.Available)
+	assert.Equal(t, "denied by policy: exceeds submitter limit of shared: cpu requested 2, available 1", rejection.Error())
+
+	// Each submitter has its own allowance, given back as its jobs finish
+	assert.Nil(t, policy.Admit("job-3", job(other, 2, 2)))
+	policy.Release("job-1")
+	assert.Nil(t, policy.Admit("job-2", job(submitter, 2, 2)))
+
+	long := job(submitter, 1, 1)
+	long.Resources.MaxDuration = 120
+	rejection = policy.Admit("job-4", long)
+	require.NotNil(t, rejection)
+	assert.Equal(t, "max_duration", rejection.Resource)
+}
+
+func TestPolicyMaxResourcesUndeclared(t *testing.T) {
+	submitter := newTestPeerID(t)
+	policy, err := ParsePolicy([]byte(`{"rules":[{"name":"shared","peers":["*"],"max_resources":{"cpu":4,"ram":8,"max_duration":60}}]}`))
+	require.NoError(t, err)
+
+	// a job declaring nothing is held to the whole of each limit
+	request := &shared.DeployRequest{SourcePeerID: submitter, Program: "sleep"}
+	require.Nil(t, policy.Admit("job-1", request))
+	assert.Equal(t, shared.Resources{CPU: 4, RAM: 8, MaxDuration: 60}, request.Resources)
+
+	rejection := policy.Admit("job-2", &shared.DeployRequest{SourcePeerID: submitter, Program: "sleep"})
+	require.NotNil(t, rejection)
+	assert.Equal(t, "cpu", rejection.Resource)
+
+	policy.Release("job-1")
+	request = &shared.DeployRequest{SourcePeerID: submitter, Program: "sleep", Resources: shared.Resources{CPU: 1, MaxDuration: 10}}
+	require.Nil(t, policy.Admit("job-2", request))
+	assert.Equal(t, shared.Resources{CPU: 1, RAM: 8, MaxDuration: 10}, request.Resources)
+}
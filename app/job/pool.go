@@ -0,0 +1,66 @@
+package job
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrQueueFull is returned when every slot is busy and the queue has no room left
+var ErrQueueFull = errors.New("job queue is full")
+
+// Pool bounds how many jobs run at once on this node and how many may wait for a slot
+type Pool struct {
+	slots    chan struct{} // held by running jobs
+	admitted chan struct{} // held by running and queued jobs
+}
+
+// NewPool creates a pool running up to slots jobs at once with up to queueLength more waiting
+func NewPool(slots, queueLength int) *Pool {
+	if slots < 1 {
+		slots = 1
+	}
+	if queueLength < 0 {
+		queueLength = 0
+	}
+	return &Pool{
+		slots:    make(chan struct{}, slots),
+		admitted: make(chan struct{}, slots+queueLength),
+	}
+}
+
+// Enqueue takes a place in the queue, failing fast with ErrQueueFull when there is none
+func (p *Pool) Enqueue() error {
+	select {
+	case p.admitted <- struct{}{}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Dequeue gives back a place in the queue without running the job
+func (p *Pool) Dequeue() {
+	<-p.admitted
+}
+
+// Acquire waits for a free slot for a job that was enqueued
+func (p *Pool) Acquire(ctx context.Context) error {
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees the slot and the queue place held by a finished job
+func (p *Pool) Release() {
+	<-p.slots
+	<-p.admitted
+}
+
+// Stats returns the number of running and queued jobs
+func (p *Pool) Stats() (running int, queued int) {
+	running = len(p.slots)
+	return running, len(p.admitted) - running
+}
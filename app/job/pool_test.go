@@ -0,0 +1,40 @@
+package job
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPool(t *testing.T) {
+	pool := NewPool(2, 1)
+
+	// two jobs run, one waits, the fourth is turned away
+	for i := 0; i < 3; i++ {
+		require.NoError(t, pool.Enqueue())
+	}
+	assert.ErrorIs(t, pool.Enqueue(), ErrQueueFull)
+
+	require.NoError(t, pool.Acquire(context.Background()))
+	require.NoError(t, pool.Acquire(context.Background()))
+	running, queued := pool.Stats()
+	assert.Equal(t, 2, running)
+	assert.Equal(t, 1, queued)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, pool.Acquire(ctx), context.DeadlineExceeded)
+
+	acquired := make(chan error)
+	go func() { acquired <- pool.Acquire(context.Background()) }()
+	pool.Release()
+	assert.NoError(t, <-acquired)
+
+	running, queued = pool.Stats()
+	assert.Equal(t, 2, running)
+	assert.Equal(t, 0, queued)
+	assert.NoError(t, pool.Enqueue())
+}
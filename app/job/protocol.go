@@ -0,0 +1,183 @@
+package job
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	"nunet/app/shared"
+)
+
+// ProtocolID is the libp2p protocol jobs are dispatched over, point-to-point
+const ProtocolID = protocol.ID("/nunet/job/1.0.0")
+
+// maxMessageBytes bounds a single message read from a stream, well above the largest stdin
+// and output a job carries, so a peer can't make the node buffer without end
+var maxMessageBytes int64 = 32 << 20
+
+// maxOutputBytes bounds the output a job keeps, whatever MaxOutputBytes says, so its
+// response leaves room for the rest and stays under maxMessageBytes
+func maxOutputBytes(configured int) int {
+	if limit := int(maxMessageBytes / 2); configured > limit {
+		return limit
+	}
+	return configured
+}
+
+// requestReadTimeout bounds how long a peer opening a job stream has to send its request
+var requestReadTimeout = 30 * time.Second
+
+// errMessageTooLarge is returned when a message goes over maxMessageBytes
+var errMessageTooLarge = errors.New("message too large")
+
+// MessageType identifies the payload carried by a Message
+type MessageType string
+
+const (
+	MessageDeployRequest  MessageType = "deploy_request"
+	MessageDeployResponse MessageType = "deploy_response"
+	MessageCancel         MessageType = "cancel" // sent by the submitter to stop the job
+	MessageLog            MessageType = "log"    // output of the job, sent while it runs
+	MessageStatus         MessageType = "status" // progress of the job on the executing peer
+)
+
+// Message is the envelope exchanged on a job stream, encoded as one JSON document per message
+type Message struct {
+	Type     MessageType            `json:"type"`
+	Request  *shared.DeployRequest  `json:"request,omitempty"`
+	Response *shared.DeployResponse `json:"response,omitempty"`
+	Log      *shared.LogEntry       `json:"log,omitempty"`
+	Status   shared.JobStatus       `json:"status,omitempty"`
+}
+
+// jobStream wraps a libp2p stream with JSON message framing
+type jobStream struct {
+	network.Stream
+	mu    sync.Mutex // guards enc
+	enc   *json.Encoder
+	dec   *json.Decoder
+	limit *messageLimit
+}
+
+func newJobStream(s network.Stream) *jobStream {
+	limit := &messageLimit{r: s}
+	return &jobStream{
+		Stream: s,
+		enc:    json.NewEncoder(s),
+		dec:    json.NewDecoder(limit),
+		limit:  limit,
+	}
+}
+
+// messageLimit is the reader decoding a message, failing once it has read more than a
+// message may take. It's reset for every message, as the stream carries many.
+type messageLimit struct {
+	r io.Reader
+	n int64 // bytes left for the message being decoded
+}
+
+func (l *messageLimit) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, errMessageTooLarge
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+// Send writes a message to the stream. It is safe for concurrent use.
+func (s *jobStream) Send(msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.enc.Encode(msg); err != nil {
+		return fmt.Errorf("error sending %s message: %w", msg.Type, err)
+	}
+	return nil
+}
+
+// logQueueSize bounds the messages waiting to be sent to a job's submitter while it runs.
+// Messages past it are dropped: a submitter that far behind gets the output in the job's response.
+const logQueueSize = 1024
+
+// logSendTimeout bounds how long a message may take to send before the submitter is given up on
+var logSendTimeout = 10 * time.Second
+
+// responseSendTimeout bounds how long sending a job's response, with all its output, may take
+var responseSendTimeout = time.Minute
+
+// logSender sends the output and progress of a running job to its submitter in the
+// background, so a submitter that stops reading never holds up the job
+type logSender struct {
+	stream   *jobStream
+	messages chan Message
+	done     chan struct{}
+}
+
+func newLogSender(stream *jobStream) *logSender {
+	s := &logSender{
+		stream:   stream,
+		messages: make(chan Message, logQueueSize),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Send queues the message, dropping it if the queue is full. It never blocks.
+func (s *logSender) Send(msg Message) {
+	select {
+	case s.messages <- msg:
+	default:
+	}
+}
+
+// Report queues a message that must not be dropped, such as a status change, waiting for room.
+// It only blocks as long as sending the queued messages takes, bounded by logSendTimeout.
+func (s *logSender) Report(msg Message) {
+	s.messages <- msg
+}
+
+// Close waits for the queued messages to be sent. Nothing may be queued after it.
+func (s *logSender) Close() {
+	close(s.messages)
+	<-s.done
+}
+
+func (s *logSender) run() {
+	defer close(s.done)
+	failed := false
+	for msg := range s.messages {
+		if failed {
+			continue
+		}
+		s.stream.SetWriteDeadline(time.Now().Add(logSendTimeout))
+		if err := s.stream.Send(msg); err != nil {
+			// the stream can't be trusted after a partial write; the job is cancelled as
+			// its submitter is gone
+			fmt.Println("Error sending job progress:", err)
+			s.stream.Reset()
+			failed = true
+		}
+	}
+	s.stream.SetWriteDeadline(time.Time{})
+}
+
+// Receive reads the next message from the stream
+func (s *jobStream) Receive() (Message, error) {
+	var msg Message
+	s.limit.n = maxMessageBytes
+	if err := s.dec.Decode(&msg); err != nil {
+		return msg, fmt.Errorf("error reading message: %w", err)
+	}
+	return msg, nil
+}
@@ -0,0 +1,827 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	libp2p "github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nunet/app/blob"
+	"nunet/app/executor"
+	"nunet/app/shared"
+	"nunet/pkg"
+)
+
+// newTestHosts starts two connected libp2p hosts listening on loopback
+func newTestHosts(t *testing.T) (host.Host, host.Host) {
+	t.Helper()
+
+	var hosts []host.Host
+	for i := 0; i < 2; i++ {
+		h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		hosts = append(hosts, h)
+	}
+
+	err := hosts[0].Connect(context.Background(), peer.AddrInfo{ID: hosts[1].ID(), Addrs: hosts[1].Addrs()})
+	require.NoError(t, err)
+	return hosts[0], hosts[1]
+}
+
+// newTestJob returns a Job on the host that runs up to one job at a time, for at most a minute
+func newTestJob(t *testing.T, h host.Host) *Job {
+	t.Helper()
+
+	workspaces, err := NewWorkspaces(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	blobs, err := blob.NewStore(t.TempDir())
+	require.NoError(t, err)
+	exchange := blob.NewExchange(h, blobs, 0)
+	exchange.Serve()
+	return &Job{
+		Host:       h,
+		Registry:   NewRegistry(),
+		Logs:       NewLogs(),
+		Admission:  NewAdmission(time.Minute, workspaces.Root),
+		Pool:       NewPool(1, 0),
+		Executors:  executor.NewSet(executor.NewProcess()),
+		Workspaces: workspaces,
+		Blobs:      blobs,
+		Exchange:   exchange,
+	}
+}
+
+func TestSendDeploymentRequest(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	submitterHost, executorHost := newTestHosts(t)
+	submitter := newTestJob(t, submitterHost)
+	executor := newTestJob(t, executorHost)
+	executor.setStreamHandler(ctx)
+
+	jobID, err := submitter.SendDeploymentRequest(ctx, shared.DeployRequest{
+		SourcePeerID: submitterHost.ID().String(),
+		TargetPeerID: executorHost.ID().String(),
+		Program:      "echo",
+		Arguments:    []string{"hello"},
+	})
+	require.NoError(t, err)
+
+	record, err := submitter.WaitForJob(ctx, jobID)
+	require.NoError(t, err)
+	assert.Equal(t, shared.JobStatusSucceeded, record.Status)
+	assert.Equal(t, shared.JobRoleSubmitter, record.Role)
+	assert.Equal(t, executorHost.ID().String(), record.Response.TargetPeerID)
+	require.NotNil(t, record.Response.ExitCode)
+	assert.Equal(t, 0, *record.Response.ExitCode)
+	require.Len(t, record.Response.Outputs, 1)
+	assert.Equal(t, "hello", record.Response.Outputs[0].Text)
+
+	executed, ok := executor.GetJob(jobID)
+	assert.True(t, ok)
+	assert.Equal(t, shared.JobRoleExecutor, executed.Role)
+	assert.Equal(t, shared.JobStatusSucceeded, executed.Status)
+	assert.Equal(t, submitterHost.ID().String(), executed.Request.SourcePeerID)
+}
+
+func TestJobEnvWorkDirAndStdin(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	submitterHost, executorHost := newTestHosts(t)
+	submitter := newTestJob(t, submitterHost)
+	executor := newTestJob(t, executorHost)
+	executor.EnvPolicy = EnvPolicy{Deny: DefaultEnvDeny}
+	executor.setStreamHandler(ctx)
+
+	jobID, err := submitter.SendDeploymentRequest(ctx, shared.DeployRequest{
+		TargetPeerID: executorHost.ID().String(),
+		Program:      "sh",
+		Arguments:    []string{"-c", "echo $GREETING; pwd; cat; echo; echo $TMPDIR"},
+		Env:          map[string]string{"GREETING": "hello"},
+		WorkDir:      "data/in",
+		Stdin:        "from stdin",
+	})
+	require.NoError(t, err)
+
+	record, err := submitter.WaitForJob(ctx, jobID)
+	require.NoError(t, err)
+	require.Equal(t, shared.JobStatusSucceeded, record.Status, record.Response.Err)
+	require.Len(t, record.Response.Outputs, 4)
+	assert.Equal(t, "hello", record.Response.Outputs[0].Text)
+	assert.Equal(t, filepath.Join(executor.Workspaces.Root, jobID, "data/in"), record.Response.Outputs[1].Text)
+	assert.Equal(t, "from stdin", record.Response.Outputs[2].Text)
+	assert.Equal(t, filepath.Join(executor.Workspaces.Root, jobID, ".tmp"), record.Response.Outputs[3].Text)
+	assert.NoDirExists(t, filepath.Join(executor.Workspaces.Root, jobID)) // removed once the job is done
+
+	// variables the node denies get the job rejected
+	jobID, err = submitter.SendDeploymentRequest(ctx, shared.DeployRequest{
+		TargetPeerID: executorHost.ID().String(),
+		Program:      "true",
+		Env:          map[string]string{"LD_PRELOAD": "/tmp/evil.so"},
+	})
+	require.NoError(t, err)
+
+	record, err = submitter.WaitForJob(ctx, jobID)
+	require.NoError(t, err)
+	assert.Equal(t, shared.JobStatusRejected, record.Status)
+	assert.Contains(t, record.Response.Err, "LD_PRELOAD")
+}
+
+func TestJobWorkspaceQuota(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	submitterHost, executorHost := newTestHosts(t)
+	submitter := newTestJob(t, submitterHost)
+	executor := newTestJob(t, executorHost)
+	executor.Workspaces.Quota = 1024
+	executor.setStreamHandler(ctx)
+
+	jobID, err := submitter.SendDeploymentRequest(ctx, shared.DeployRequest{
+		TargetPeerID: executorHost.ID().String(),
+		Program:      "sh",
+		Arguments:    []string{"-c", "head -c 4096 /dev/zero > big; sleep 30"},
+	})
+	require.NoError(t, err)
+
+	record, err := submitter.WaitForJob(ctx, jobID)
+	require.NoError(t, err)
+	assert.Equal(t, shared.JobStatusFailed, record.Status)
+	assert.Equal(t, ErrQuotaExceeded.Error(), record.Response.Err)
+	assert.Less(t, record.Response.ElapsedMs, int64(5000))
+}
+
+func TestJobInputFiles(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	submitterHost, executorHost := newTestHosts(t)
+	submitter := newTestJob(t, submitterHost)
+	submitter.setStreamHandler(ctx)
+	executor := newTestJob(t, executorHost)
+	executor.setStreamHandler(ctx)
+
+	jobID := NewJobID()
+	script, err := submitter.StageInput(jobID, "run.sh", strings.NewReader("cat data/in.txt\n"))
+	require.NoError(t, err)
+	data, err := submitter.StageInput(jobID, "data/in.txt", strings.NewReader("from the submitter"))
+	require.NoError(t, err)
+
+	_, err = submitter.SendDeploymentRequest(ctx, shared.DeployRequest{
+		JobID:        jobID,
+		SourcePeerID: submitterHost.ID().String(),
+		TargetPeerID: executorHost.ID().String(),
+		Program:      "sh",
+		Arguments:    []string{"run.sh"},
+		Inputs:       []shared.File{script, data},
+	})
+	require.NoError(t, err)
+
+	record, err := submitter.WaitForJob(ctx, jobID)
+	require.NoError(t, err)
+	require.Equal(t, shared.JobStatusSucceeded, record.Status, record.Response.Err)
+	require.Len(t, record.Response.Outputs, 1)
+	assert.Equal(t, "from the submitter", record.Response.Outputs[0].Text)
+	c, err := cid.Decode(data.CID)
+	require.NoError(t, err)
+	assert.False(t, submitter.Blobs.Pinned(c)) // released once the job is done
+	assert.False(t, executor.Blobs.Pinned(c))
+
+	// files that don't match their cid fail the job
+	jobID = NewJobID()
+	data, err = submitter.StageInput(jobID, "in.txt", strings.NewReader("original"))
+	require.NoError(t, err)
+	c, err = cid.Decode(data.CID)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(submitter.Blobs.Root, c.String()), []byte("tampered"), 0o600))
+
+	_, err = submitter.SendDeploymentRequest(ctx, shared.DeployRequest{
+		JobID:        jobID,
+		SourcePeerID: submitterHost.ID().String(),
+		TargetPeerID: executorHost.ID().String(),
+		Program:      "cat",
+		Arguments:    []string{"in.txt"},
+		Inputs:       []shared.File{data},
+	})
+	require.NoError(t, err)
+
+	record, err = submitter.WaitForJob(ctx, jobID)
+	require.NoError(t, err)
+	assert.Equal(t, shared.JobStatusFailed, record.Status)
+	assert.Contains(t, record.Response.Err, blob.ErrMismatch.Error())
+}
+
+func TestJobManyShortLines(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	submitterHost, executorHost := newTestHosts(t)
+	submitter := newTestJob(t, submitterHost)
+	executor := newTestJob(t, executorHost)
+	executor.setStreamHandler(ctx)
+
+	// a million one-byte lines would encode to far more than a message may carry
+	jobID, err := submitter.SendDeploymentRequest(ctx, shared.DeployRequest{
+		TargetPeerID: executorHost.ID().String(),
+		Program:      "sh",
+		Arguments:    []string{"-c", "yes | head -c 2000000"},
+	})
+	require.NoError(t, err)
+
+	record, err := submitter.WaitForJob(ctx, jobID)
+	require.NoError(t, err)
+	require.Equal(t, shared.JobStatusSucceeded, record.Status, record.Response.Err)
+	assert.True(t, record.Response.OutputTruncated)
+	data, err := json.Marshal(record.Response.Outputs)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(data), pkg.DefaultMaxOutputBytes+1024)
+}
+
+func TestJobArtifacts(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	submitterHost, executorHost := newTestHosts(t)
+	submitter := newTestJob(t, submitterHost)
+	submitter.setStreamHandler(ctx)
+	executor := newTestJob(t, executorHost)
+	executor.setStreamHandler(ctx)
+
+	jobID, err := submitter.SendDeploymentRequest(ctx, shared.DeployRequest{
+		SourcePeerID: submitterHost.ID().String(),
+		TargetPeerID: executorHost.ID().String(),
+		Program:      "sh",
+		Arguments: []string{"-c", "mkdir out && echo result > out/a.txt && echo other > out/b.log && " +
+			"echo skipped > c.txt && ln -s /etc/hostname out/link.txt && ln -s /etc etc"},
+		Artifacts: []string{"out/*.txt", "out/a.txt", "etc/hostname", "etc/*"},
+	})
+	require.NoError(t, err)
+
+	record, err := submitter.WaitForJob(ctx, jobID)
+	require.NoError(t, err)
+	require.Equal(t, shared.JobStatusSucceeded, record.Status, record.Response.Err)
+	assert.Empty(t, record.Response.ArtifactsErr)
+	require.Len(t, record.Response.Artifacts, 1) // no duplicates, symlinks, files reached through them or unmatched files
+	assert.Equal(t, "out/a.txt", record.Response.Artifacts[0].Path)
+	assert.Equal(t, int64(7), record.Response.Artifacts[0].Size)
+
+	// the submitter has its own copy
+	c, err := cid.Decode(record.Response.Artifacts[0].CID)
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(filepath.Join(executor.Blobs.Root, c.String())))
+	file, artifact, err := submitter.OpenArtifact(jobID, "out/a.txt")
+	require.NoError(t, err)
+	contents, err := io.ReadAll(file)
+	file.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "result\n", string(contents))
+	assert.Equal(t, record.Response.Artifacts[0], artifact)
+
+	_, _, err = submitter.OpenArtifact(jobID, "c.txt")
+	assert.ErrorIs(t, err, shared.ErrNoArtifact)
+	_, _, err = submitter.OpenArtifact("unknown", "out/a.txt")
+	assert.ErrorIs(t, err, shared.ErrJobNotFound)
+
+	// artifacts can be fed to later jobs by cid
+	jobID = NewJobID()
+	input, err := submitter.ReferenceInput(jobID, shared.File{Path: "in.txt", CID: artifact.CID})
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), input.Size)
+
+	_, err = submitter.SendDeploymentRequest(ctx, shared.DeployRequest{
+		JobID:        jobID,
+		SourcePeerID: submitterHost.ID().String(),
+		TargetPeerID: executorHost.ID().String(),
+		Program:      "cat",
+		Arguments:    []string{"in.txt"},
+		Inputs:       []shared.File{input},
+	})
+	require.NoError(t, err)
+
+	record, err = submitter.WaitForJob(ctx, jobID)
+	require.NoError(t, err)
+	require.Equal(t, shared.JobStatusSucceeded, record.Status, record.Response.Err)
+	require.Len(t, record.Response.Outputs, 1)
+	assert.Equal(t, "result", record.Response.Outputs[0].Text)
+}
+
+func TestSendDeploymentRequestUnsupportedPeer(t *testing.T) {
+	submitterHost, otherHost := newTestHosts(t)
+	submitter := newTestJob(t, submitterHost)
+
+	_, err := submitter.SendDeploymentRequest(context.Background(), shared.DeployRequest{
+		TargetPeerID: otherHost.ID().String(),
+		Program:      "echo",
+	})
+	assert.Error(t, err)
+
+	jobs, total := submitter.ListJobs(shared.JobFilter{})
+	assert.Equal(t, 1, total)
+	assert.Equal(t, shared.JobStatusFailed, jobs[0].Status)
+}
+
+func TestSendDeploymentRequestRejected(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	submitterHost, executorHost := newTestHosts(t)
+	submitter := newTestJob(t, submitterHost)
+	executor := newTestJob(t, executorHost)
+	executor.setStreamHandler(ctx)
+
+	jobID, err := submitter.SendDeploymentRequest(ctx, shared.DeployRequest{
+		TargetPeerID: executorHost.ID().String(),
+		Program:      "echo",
+		Resources:    shared.Resources{MaxDuration: 3600},
+	})
+	require.NoError(t, err)
+
+	record, err := submitter.WaitForJob(ctx, jobID)
+	require.NoError(t, err)
+	assert.Equal(t, shared.JobStatusRejected, record.Status)
+	require.NotNil(t, record.Response.Rejection)
+	assert.Equal(t, "max_duration", record.Response.Rejection.Resource)
+}
+
+func TestJobStreamLimits(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	timeout, limit := requestReadTimeout, maxMessageBytes
+	requestReadTimeout, maxMessageBytes = 200*time.Millisecond, 1024
+	t.Cleanup(func() { requestReadTimeout, maxMessageBytes = timeout, limit })
+
+	submitterHost, executorHost := newTestHosts(t)
+	executor := newTestJob(t, executorHost)
+	executor.setStreamHandler(ctx)
+	open := func() *jobStream {
+		s, err := submitterHost.NewStream(ctx, executorHost.ID(), ProtocolID)
+		require.NoError(t, err)
+		return newJobStream(s)
+	}
+
+	// a peer sending nothing is dropped
+	stream := open()
+	startedAt := time.Now()
+	_, err := stream.Receive()
+	assert.Error(t, err)
+	assert.Less(t, time.Since(startedAt), 5*time.Second)
+
+	// as is one sending too much
+	stream = open()
+	_, err = stream.Write([]byte(`{"type":"deploy_request","request":{"program":"echo","stdin":"` + strings.Repeat("x", 4096) + `"}}`))
+	require.NoError(t, err)
+	_, err = stream.Receive()
+	assert.Error(t, err)
+	assert.Empty(t, executor.Registry.List())
+
+	// requests within the limits still run
+	stream = open()
+	require.NoError(t, stream.Send(Message{Type: MessageDeployRequest, Request: &shared.DeployRequest{Program: "echo"}}))
+	for {
+		msg, err := stream.Receive()
+		require.NoError(t, err)
+		if msg.Type == MessageDeployResponse {
+			assert.Equal(t, shared.JobStatusSucceeded, msg.Response.Status, msg.Response.Err)
+			break
+		}
+	}
+}
+
+func TestSendDeploymentRequestInvalid(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	submitterHost, executorHost := newTestHosts(t)
+	submitter := newTestJob(t, submitterHost)
+	executor := newTestJob(t, executorHost)
+	executor.setStreamHandler(ctx)
+
+	for _, request := range []shared.DeployRequest{
+		{Program: "echo", Resources: shared.Resources{CPU: -1}},
+		{Program: "echo", Env: map[string]string{"A=B": "c"}},
+		{Program: "echo", Stdin: strings.Repeat("x", shared.MaxStdinBytes+1)},
+		{Program: "echo", Artifacts: []string{"../*"}},
+		{Program: "echo", WorkDir: "/etc"},
+		{Program: "echo", JobID: "."},
+		{Program: "echo", JobID: ".."},
+		{Program: "echo", JobID: "a/b"},
+	} {
+		request.TargetPeerID = executorHost.ID().String()
+		jobID, err := submitter.SendDeploymentRequest(ctx, request)
+		require.NoError(t, err)
+
+		record, err := submitter.WaitForJob(ctx, jobID)
+		require.NoError(t, err)
+		assert.Equal(t, shared.JobStatusRejected, record.Status)
+		require.NotNil(t, record.Response.Rejection)
+
+		_, ok := executor.Registry.Get(jobID)
+		assert.False(t, ok) // never registered on the executing peer
+	}
+}
+
+func TestSendDeploymentRequestUnsupportedRuntime(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	submitterHost, executorHost := newTestHosts(t)
+	submitter := newTestJob(t, submitterHost)
+	executor := newTestJob(t, executorHost)
+	executor.setStreamHandler(ctx)
+
+	jobID, err := submitter.SendDeploymentRequest(ctx, shared.DeployRequest{
+		TargetPeerID: executorHost.ID().String(),
+		Runtime:      "unknown",
+		Program:      "echo",
+	})
+	require.NoError(t, err)
+
+	record, err := submitter.WaitForJob(ctx, jobID)
+	require.NoError(t, err)
+	assert.Equal(t, shared.JobStatusRejected, record.Status)
+	require.NotNil(t, record.Response.Rejection)
+	assert.Contains(t, record.Response.Rejection.Reason, `runtime "unknown" is not supported`)
+}
+
+// joinTestTopic joins every host to the same deployment topic so they see each other as peers
+func joinTestTopic(t *testing.T, ctx context.Context, hosts ...host.Host) []*pubsub.Topic {
+	t.Helper()
+
+	var topics []*pubsub.Topic
+	for _, h := range hosts {
+		ps, err := pubsub.NewGossipSub(ctx, h)
+		require.NoError(t, err)
+		topic, err := ps.Join("test-deployment")
+		require.NoError(t, err)
+		_, err = topic.Subscribe()
+		require.NoError(t, err)
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+func TestSendDeploymentRequestRetriesOnAnotherPeer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	submitterHost, smallHost := newTestHosts(t)
+	largeHost, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer largeHost.Close()
+	require.NoError(t, submitterHost.Connect(ctx, peer.AddrInfo{ID: largeHost.ID(), Addrs: largeHost.Addrs()}))
+
+	topics := joinTestTopic(t, ctx, submitterHost, smallHost, largeHost)
+	submitter := newTestJob(t, submitterHost)
+	submitter.DeploymentTopic = topics[0]
+	submitter.MaxRetries = 2
+	submitter.Scheduler, _ = NewScheduler(StrategyRoundRobin)
+
+	small := newTestJob(t, smallHost)
+	small.Admission = NewAdmission(time.Second, small.Workspaces.Root)
+	small.setStreamHandler(ctx)
+	large := newTestJob(t, largeHost)
+	large.setStreamHandler(ctx)
+
+	require.Eventually(t, func() bool { return len(submitter.ListPeers()) == 2 }, 10*time.Second, 100*time.Millisecond)
+
+	jobID, err := submitter.SendDeploymentRequest(ctx, shared.DeployRequest{
+		TargetPeerID: smallHost.ID().String(),
+		Program:      "echo",
+		Resources:    shared.Resources{MaxDuration: 10},
+	})
+	require.NoError(t, err)
+
+	record, err := submitter.WaitForJob(ctx, jobID)
+	require.NoError(t, err)
+	assert.Equal(t, shared.JobStatusSucceeded, record.Status)
+	assert.Equal(t, largeHost.ID().String(), record.Request.TargetPeerID)
+	require.Len(t, record.Attempts, 2)
+	assert.Equal(t, shared.JobStatusRejected, record.Attempts[0].Status)
+	assert.Equal(t, smallHost.ID().String(), record.Attempts[0].TargetPeerID)
+	assert.Equal(t, shared.JobStatusSucceeded, record.Attempts[1].Status)
+}
+
+func TestSendDeploymentRequestRetriesFailedOnlyOnRequest(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	submitterHost, firstHost := newTestHosts(t)
+	secondHost, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer secondHost.Close()
+	require.NoError(t, submitterHost.Connect(ctx, peer.AddrInfo{ID: secondHost.ID(), Addrs: secondHost.Addrs()}))
+
+	topics := joinTestTopic(t, ctx, submitterHost, firstHost, secondHost)
+	submitter := newTestJob(t, submitterHost)
+	submitter.DeploymentTopic = topics[0]
+	submitter.MaxRetries = 2
+	submitter.Scheduler, _ = NewScheduler(StrategyRoundRobin)
+	newTestJob(t, firstHost).setStreamHandler(ctx)
+	newTestJob(t, secondHost).setStreamHandler(ctx)
+
+	require.Eventually(t, func() bool { return len(submitter.ListPeers()) == 2 }, 10*time.Second, 100*time.Millisecond)
+
+	request := shared.DeployRequest{
+		TargetPeerID: firstHost.ID().String(),
+		Program:      "false",
+		Resources:    shared.Resources{MaxDuration: 10},
+	}
+	jobID, err := submitter.SendDeploymentRequest(ctx, request)
+	require.NoError(t, err)
+
+	record, err := submitter.WaitForJob(ctx, jobID)
+	require.NoError(t, err)
+	assert.Equal(t, shared.JobStatusFailed, record.Status)
+	assert.Len(t, record.Attempts, 1) // the program ran, so another peer wouldn't do better
+
+	request.RetryFailed = true
+	jobID, err = submitter.SendDeploymentRequest(ctx, request)
+	require.NoError(t, err)
+
+	record, err = submitter.WaitForJob(ctx, jobID)
+	require.NoError(t, err)
+	assert.Equal(t, shared.JobStatusFailed, record.Status)
+	require.Len(t, record.Attempts, 2) // only two peers to try
+	assert.Equal(t, firstHost.ID().String(), record.Attempts[0].TargetPeerID)
+	assert.Equal(t, secondHost.ID().String(), record.Attempts[1].TargetPeerID)
+}
+
+func TestSendDeploymentRequestTimesOut(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	submitterHost, executorHost := newTestHosts(t)
+	submitter := newTestJob(t, submitterHost)
+	executor := newTestJob(t, executorHost)
+	executor.setStreamHandler(ctx)
+
+	jobID, err := submitter.SendDeploymentRequest(ctx, shared.DeployRequest{
+		TargetPeerID: executorHost.ID().String(),
+		Program:      "sleep",
+		Arguments:    []string{"5"},
+		Resources:    shared.Resources{MaxDuration: 1},
+	})
+	require.NoError(t, err)
+
+	record, err := submitter.WaitForJob(ctx, jobID)
+	require.NoError(t, err)
+	assert.Equal(t, shared.JobStatusTimedOut, record.Status)
+	// the timeout starts counting just before the program does
+	assert.GreaterOrEqual(t, record.Response.ElapsedMs, int64(900))
+	assert.Less(t, record.Response.ElapsedMs, int64(5000))
+	assert.Equal(t, "killed", record.Response.Signal)
+	assert.NotZero(t, record.Response.PID)
+	require.NotNil(t, record.Response.Usage)
+}
+
+func TestCancelJob(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	submitterHost, executorHost := newTestHosts(t)
+	submitter := newTestJob(t, submitterHost)
+	submitter.MaxRetries = 2
+	executor := newTestJob(t, executorHost)
+	executor.setStreamHandler(ctx)
+
+	jobID, err := submitter.SendDeploymentRequest(ctx, shared.DeployRequest{
+		TargetPeerID: executorHost.ID().String(),
+		Program:      "sh",
+		Arguments:    []string{"-c", "sleep 30 & sleep 30"},
+	})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		record, _ := executor.GetJob(jobID)
+		return record.Status == shared.JobStatusRunning
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, submitter.CancelJob(jobID))
+
+	record, err := submitter.WaitForJob(ctx, jobID)
+	require.NoError(t, err)
+	assert.Equal(t, shared.JobStatusCancelled, record.Status)
+	assert.Len(t, record.Attempts, 1) // cancelled jobs aren't retried
+	assert.Less(t, record.Response.ElapsedMs, int64(5000))
+
+	assert.ErrorIs(t, submitter.CancelJob(jobID), shared.ErrJobFinished)
+	assert.ErrorIs(t, submitter.CancelJob("unknown"), shared.ErrJobNotFound)
+}
+
+func TestJobCancelledWithoutSubmitter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	submitterHost, executorHost := newTestHosts(t)
+	submitter := newTestJob(t, submitterHost)
+	submitter.ResponseTimeout = 500 * time.Millisecond
+	executor := newTestJob(t, executorHost)
+	executor.setStreamHandler(ctx)
+
+	// the submitter giving up tells the executing peer
+	jobID, err := submitter.SendDeploymentRequest(ctx, shared.DeployRequest{
+		TargetPeerID: executorHost.ID().String(),
+		Program:      "sleep",
+		Arguments:    []string{"30"},
+	})
+	require.NoError(t, err)
+	record, err := submitter.WaitForJob(ctx, jobID)
+	require.NoError(t, err)
+	assert.Equal(t, shared.JobStatusTimedOut, record.Status, record.Response.Err)
+	record, err = executor.WaitForJob(ctx, jobID)
+	require.NoError(t, err)
+	assert.Equal(t, shared.JobStatusCancelled, record.Status)
+
+	// so does the stream breaking
+	s, err := submitterHost.NewStream(ctx, executorHost.ID(), ProtocolID)
+	require.NoError(t, err)
+	stream := newJobStream(s)
+	request := shared.DeployRequest{JobID: NewJobID(), Program: "sleep", Arguments: []string{"30"}}
+	require.NoError(t, stream.Send(Message{Type: MessageDeployRequest, Request: &request}))
+	require.Eventually(t, func() bool {
+		record, _ := executor.GetJob(request.JobID)
+		return record.Status == shared.JobStatusRunning
+	}, 5*time.Second, 10*time.Millisecond)
+	stream.Reset()
+	record, err = executor.WaitForJob(ctx, request.JobID)
+	require.NoError(t, err)
+	assert.Equal(t, shared.JobStatusCancelled, record.Status)
+}
+
+func TestJobSubmitterStopsReading(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	logTimeout, responseTimeout := logSendTimeout, responseSendTimeout
+	logSendTimeout, responseSendTimeout = 200*time.Millisecond, 200*time.Millisecond
+	t.Cleanup(func() { logSendTimeout, responseSendTimeout = logTimeout, responseTimeout })
+
+	submitterHost, executorHost := newTestHosts(t)
+	executor := newTestJob(t, executorHost)
+	executor.setStreamHandler(ctx)
+
+	// a submitter that sends its request and never reads: the output soon fills the stream's window
+	s, err := submitterHost.NewStream(ctx, executorHost.ID(), ProtocolID)
+	require.NoError(t, err)
+	defer s.Reset()
+	request := shared.DeployRequest{JobID: NewJobID(), Program: "sh", Arguments: []string{"-c", "yes | head -c 2000000"}}
+	require.NoError(t, newJobStream(s).Send(Message{Type: MessageDeployRequest, Request: &request}))
+
+	// the job still ends and gives back its slot
+	require.Eventually(t, func() bool {
+		_, ok := executor.GetJob(request.JobID)
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+	record, err := executor.WaitForJob(ctx, request.JobID)
+	require.NoError(t, err)
+	assert.True(t, record.Status.IsTerminal(), record.Status)
+	require.Eventually(t, func() bool {
+		running, queued := executor.Pool.Stats()
+		return running == 0 && queued == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestJobLogsAreStreamed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	submitterHost, executorHost := newTestHosts(t)
+	submitter := newTestJob(t, submitterHost)
+	executor := newTestJob(t, executorHost)
+	executor.setStreamHandler(ctx)
+
+	jobID, err := submitter.SendDeploymentRequest(ctx, shared.DeployRequest{
+		TargetPeerID: executorHost.ID().String(),
+		Program:      "sh",
+		Arguments:    []string{"-c", "echo first; sleep 1; echo second >&2; sleep 30"},
+	})
+	require.NoError(t, err)
+
+	// output shows up while the job is still running
+	var entries []shared.LogEntry
+	require.Eventually(t, func() bool {
+		entries, _, _, err = submitter.ReadLogs(jobID, 0)
+		return err == nil && len(entries) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "first", entries[0].Text)
+	assert.Equal(t, "stdout", entries[0].Stream)
+	assert.Equal(t, "stderr", entries[1].Stream)
+	assert.Equal(t, 1, entries[1].Seq)
+
+	_, done, more, err := submitter.ReadLogs(jobID, 2)
+	require.NoError(t, err)
+	assert.False(t, done)
+
+	require.NoError(t, submitter.CancelJob(jobID))
+	select {
+	case <-more:
+	case <-ctx.Done():
+		t.Fatal("log was not closed when the job ended")
+	}
+	_, done, _, err = submitter.ReadLogs(jobID, 2)
+	require.NoError(t, err)
+	assert.True(t, done)
+}
+
+// pullingRuntime pulls for as long as pull stays open before running jobs as processes
+type pullingRuntime struct {
+	pull chan struct{}
+}
+
+func (r pullingRuntime) Name() string { return "pulling" }
+
+func (r pullingRuntime) Start(ctx context.Context, spec executor.Spec) (executor.Execution, error) {
+	spec.OnPull("pulling image")
+	<-r.pull
+	return executor.NewProcess().Start(ctx, spec)
+}
+
+func TestJobReportsStatus(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	submitterHost, executorHost := newTestHosts(t)
+	submitter := newTestJob(t, submitterHost)
+	executorJob := newTestJob(t, executorHost)
+	pull := make(chan struct{})
+	executorJob.Executors = executor.NewSet(pullingRuntime{pull: pull})
+	executorJob.setStreamHandler(ctx)
+
+	jobID, err := submitter.SendDeploymentRequest(ctx, shared.DeployRequest{
+		TargetPeerID: executorHost.ID().String(),
+		Runtime:      "pulling",
+		Program:      "echo",
+		Arguments:    []string{"done"},
+	})
+	require.NoError(t, err)
+
+	// the submitter follows the job's progress on the executing peer
+	require.Eventually(t, func() bool {
+		record, _ := submitter.GetJob(jobID)
+		return record.Status == shared.JobStatusPulling
+	}, 5*time.Second, 10*time.Millisecond)
+	close(pull)
+
+	record, err := submitter.WaitForJob(ctx, jobID)
+	require.NoError(t, err)
+	require.Equal(t, shared.JobStatusSucceeded, record.Status, record.Response.Err)
+
+	entries, _, _, err := submitter.ReadLogs(jobID, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, pkg.StreamPull, entries[0].Stream)
+	assert.Equal(t, "pulling image", entries[0].Text)
+	assert.Equal(t, "done", entries[1].Text)
+}
+
+func TestSendDeploymentRequestDeniedByPolicy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	submitterHost, executorHost := newTestHosts(t)
+	submitter := newTestJob(t, submitterHost)
+	executor := newTestJob(t, executorHost)
+	policy, err := ParsePolicy([]byte(fmt.Sprintf(`{"rules":[{"peers":[%q],"programs":[{"path":"echo"}]}]}`, submitterHost.ID())))
+	require.NoError(t, err)
+	executor.Policy = policy
+	executor.setStreamHandler(ctx)
+
+	jobID, err := submitter.SendDeploymentRequest(ctx, shared.DeployRequest{
+		TargetPeerID: executorHost.ID().String(),
+		Program:      "sh",
+		Arguments:    []string{"-c", "rm -rf ~"},
+	})
+	require.NoError(t, err)
+
+	record, err := submitter.WaitForJob(ctx, jobID)
+	require.NoError(t, err)
+	assert.Equal(t, shared.JobStatusRejected, record.Status)
+	require.NotNil(t, record.Response.Rejection)
+	assert.True(t, record.Response.Rejection.DeniedByPolicy)
+	assert.Equal(t, `denied by policy: program "sh" is not allowed`, record.Response.Err)
+
+	jobID, err = submitter.SendDeploymentRequest(ctx, shared.DeployRequest{
+		TargetPeerID: executorHost.ID().String(),
+		Program:      "echo",
+		Arguments:    []string{"hello"},
+	})
+	require.NoError(t, err)
+	record, err = submitter.WaitForJob(ctx, jobID)
+	require.NoError(t, err)
+	assert.Equal(t, shared.JobStatusSucceeded, record.Status)
+}
@@ -0,0 +1,248 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"nunet/app/shared"
+)
+
+// Registry keeps track of jobs submitted or executed by this node
+type Registry struct {
+	mu      sync.RWMutex
+	records map[string]*shared.JobRecord
+	done    map[string]chan struct{} // closed once the job reaches a terminal status
+}
+
+// NewRegistry creates an empty job registry
+func NewRegistry() *Registry {
+	return &Registry{
+		records: make(map[string]*shared.JobRecord),
+		done:    make(map[string]chan struct{}),
+	}
+}
+
+// NewJobID generates a unique job identifier
+func NewJobID() string {
+	return uuid.NewString()
+}
+
+// Add registers a new job with the given role and initial status
+func (r *Registry) Add(role shared.JobRole, status shared.JobStatus, request shared.DeployRequest) error {
+	if request.JobID == "" {
+		return fmt.Errorf("job id is required")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.records[request.JobID]; ok {
+		return fmt.Errorf("job %s already exists", request.JobID)
+	}
+
+	now := time.Now()
+	r.records[request.JobID] = &shared.JobRecord{
+		ID:        request.JobID,
+		Role:      role,
+		Status:    status,
+		Request:   request,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	r.done[request.JobID] = make(chan struct{})
+	r.notify(request.JobID, status)
+	return nil
+}
+
+// SetStatus moves a job to the given status. Jobs in a terminal state are left untouched.
+func (r *Registry) SetStatus(id string, status shared.JobStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.records[id]
+	if !ok {
+		return fmt.Errorf("job %s not found", id)
+	}
+	if record.Status.IsTerminal() {
+		return fmt.Errorf("job %s already %s", id, record.Status)
+	}
+
+	record.Status = status
+	record.UpdatedAt = time.Now()
+	r.notify(id, status)
+	return nil
+}
+
+// Complete stores the final response of a job and moves it to the response's status
+func (r *Registry) Complete(response shared.DeployResponse) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.records[response.JobID]
+	if !ok {
+		return fmt.Errorf("job %s not found", response.JobID)
+	}
+	if record.Status.IsTerminal() {
+		return fmt.Errorf("job %s already %s", response.JobID, record.Status)
+	}
+
+	status := response.Status
+	if !status.IsTerminal() { // older peers don't report a status
+		status = shared.JobStatusSucceeded
+		if response.Err != "" {
+			status = shared.JobStatusFailed
+		}
+	}
+
+	record.Status = status
+	record.Response = &response
+	record.UpdatedAt = time.Now()
+	r.notify(response.JobID, status)
+	return nil
+}
+
+// AddAttempt records the outcome of sending the job to one peer
+func (r *Registry) AddAttempt(id string, attempt shared.JobAttempt) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.records[id]
+	if !ok {
+		return fmt.Errorf("job %s not found", id)
+	}
+
+	record.Attempts = append(record.Attempts, attempt)
+	record.UpdatedAt = time.Now()
+	return nil
+}
+
+// Retarget points a pending job at another peer and moves it back to submitted
+func (r *Registry) Retarget(id string, targetPeerID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.records[id]
+	if !ok {
+		return fmt.Errorf("job %s not found", id)
+	}
+	if record.Status.IsTerminal() {
+		return fmt.Errorf("job %s already %s", id, record.Status)
+	}
+
+	record.Request.TargetPeerID = targetPeerID
+	record.Status = shared.JobStatusSubmitted
+	record.UpdatedAt = time.Now()
+	return nil
+}
+
+// notify wakes up everyone waiting on the job once it is finished. Callers must hold the lock.
+func (r *Registry) notify(id string, status shared.JobStatus) {
+	if status.IsTerminal() {
+		close(r.done[id])
+	}
+}
+
+// Wait blocks until the job reaches a terminal status or the context is done
+func (r *Registry) Wait(ctx context.Context, id string) (shared.JobRecord, error) {
+	r.mu.RLock()
+	done, ok := r.done[id]
+	r.mu.RUnlock()
+	if !ok {
+		return shared.JobRecord{}, fmt.Errorf("job %s not found", id)
+	}
+
+	select {
+	case <-done:
+		record, _ := r.Get(id)
+		return record, nil
+	case <-ctx.Done():
+		record, _ := r.Get(id)
+		return record, ctx.Err()
+	}
+}
+
+// Get returns a copy of the job with the given id
+func (r *Registry) Get(id string) (shared.JobRecord, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	record, ok := r.records[id]
+	if !ok {
+		return shared.JobRecord{}, false
+	}
+	return *record, true
+}
+
+// List returns a copy of all known jobs, oldest first
+func (r *Registry) List() []shared.JobRecord {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	records := make([]shared.JobRecord, 0, len(r.records))
+	for _, record := range r.records {
+		records = append(records, *record)
+	}
+	sort.Slice(records, func(i, k int) bool {
+		return records[i].CreatedAt.Before(records[k].CreatedAt)
+	})
+	return records
+}
+
+// Prune forgets finished jobs last updated more than maxAge ago, and the oldest finished jobs
+// beyond the newest maxFinished, returning their ids. Either bound is left out if 0.
+func (r *Registry) Prune(maxAge time.Duration, maxFinished int) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var finished []*shared.JobRecord
+	for _, record := range r.records {
+		if record.Status.IsTerminal() {
+			finished = append(finished, record)
+		}
+	}
+	sort.Slice(finished, func(i, k int) bool {
+		return finished[i].UpdatedAt.After(finished[k].UpdatedAt)
+	})
+
+	var pruned []string
+	for i, record := range finished {
+		expired := maxAge > 0 && time.Since(record.UpdatedAt) > maxAge
+		if expired || (maxFinished > 0 && i >= maxFinished) {
+			delete(r.records, record.ID)
+			delete(r.done, record.ID)
+			pruned = append(pruned, record.ID)
+		}
+	}
+	return pruned
+}
+
+// Find returns the page of jobs matching the filter, newest first, along with the total number of matches
+func (r *Registry) Find(filter shared.JobFilter) ([]shared.JobRecord, int) {
+	r.mu.RLock()
+	matches := make([]shared.JobRecord, 0)
+	for _, record := range r.records {
+		if filter.Match(*record) {
+			matches = append(matches, *record)
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(matches, func(i, k int) bool {
+		return matches[i].CreatedAt.After(matches[k].CreatedAt)
+	})
+
+	total := len(matches)
+	if filter.Offset >= total {
+		return []shared.JobRecord{}, total
+	}
+	matches = matches[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(matches) {
+		matches = matches[:filter.Limit]
+	}
+	return matches, total
+}
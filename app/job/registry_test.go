@@ -0,0 +1,138 @@
+package job
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"nunet/app/shared"
+)
+
+func TestRegistryLifecycle(t *testing.T) {
+	registry := NewRegistry()
+	request := shared.DeployRequest{JobID: NewJobID(), Program: "echo"}
+
+	assert.NoError(t, registry.Add(shared.JobRoleExecutor, shared.JobStatusAccepted, request))
+	assert.Error(t, registry.Add(shared.JobRoleExecutor, shared.JobStatusAccepted, request))
+
+	assert.NoError(t, registry.SetStatus(request.JobID, shared.JobStatusRunning))
+	assert.NoError(t, registry.Complete(shared.DeployResponse{JobID: request.JobID, Status: shared.JobStatusTimedOut}))
+
+	record, ok := registry.Get(request.JobID)
+	assert.True(t, ok)
+	assert.Equal(t, shared.JobRoleExecutor, record.Role)
+	assert.Equal(t, shared.JobStatusTimedOut, record.Status)
+	assert.NotNil(t, record.Response)
+
+	// terminal jobs don't move again
+	assert.Error(t, registry.SetStatus(request.JobID, shared.JobStatusRunning))
+	assert.Error(t, registry.SetStatus("unknown", shared.JobStatusRunning))
+}
+
+func TestRegistryCompleteWithoutStatus(t *testing.T) {
+	registry := NewRegistry()
+	ok := shared.DeployRequest{JobID: NewJobID()}
+	failed := shared.DeployRequest{JobID: NewJobID()}
+	assert.NoError(t, registry.Add(shared.JobRoleSubmitter, shared.JobStatusSubmitted, ok))
+	assert.NoError(t, registry.Add(shared.JobRoleSubmitter, shared.JobStatusSubmitted, failed))
+
+	assert.NoError(t, registry.Complete(shared.DeployResponse{JobID: ok.JobID}))
+	assert.NoError(t, registry.Complete(shared.DeployResponse{JobID: failed.JobID, Err: "exit status 1"}))
+
+	records := registry.List()
+	assert.Len(t, records, 2)
+	assert.Equal(t, ok.JobID, records[0].ID)
+	assert.Equal(t, shared.JobStatusSucceeded, records[0].Status)
+	assert.Equal(t, shared.JobStatusFailed, records[1].Status)
+}
+
+func TestRegistryFind(t *testing.T) {
+	registry := NewRegistry()
+	for i := 0; i < 5; i++ {
+		target := "peer-a"
+		if i%2 == 1 {
+			target = "peer-b"
+		}
+		request := shared.DeployRequest{JobID: NewJobID(), TargetPeerID: target}
+		assert.NoError(t, registry.Add(shared.JobRoleSubmitter, shared.JobStatusSubmitted, request))
+	}
+
+	records, total := registry.Find(shared.JobFilter{TargetPeerID: "peer-a"})
+	assert.Equal(t, 3, total)
+	assert.Len(t, records, 3)
+	assert.True(t, !records[0].CreatedAt.Before(records[2].CreatedAt)) // newest first
+
+	records, total = registry.Find(shared.JobFilter{Offset: 1, Limit: 2})
+	assert.Equal(t, 5, total)
+	assert.Len(t, records, 2)
+
+	records, total = registry.Find(shared.JobFilter{Status: shared.JobStatusSucceeded})
+	assert.Equal(t, 0, total)
+	assert.Empty(t, records)
+
+	records, _ = registry.Find(shared.JobFilter{Offset: 10})
+	assert.Empty(t, records)
+}
+
+func TestRegistryWait(t *testing.T) {
+	registry := NewRegistry()
+	request := shared.DeployRequest{JobID: NewJobID()}
+	assert.NoError(t, registry.Add(shared.JobRoleSubmitter, shared.JobStatusSubmitted, request))
+
+	// times out while the job is still pending
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	record, err := registry.Wait(ctx, request.JobID)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, shared.JobStatusSubmitted, record.Status)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		registry.Complete(shared.DeployResponse{JobID: request.JobID, Status: shared.JobStatusSucceeded, PID: 42})
+	}()
+
+	record, err = registry.Wait(context.Background(), request.JobID)
+	assert.NoError(t, err)
+	assert.Equal(t, shared.JobStatusSucceeded, record.Status)
+	assert.Equal(t, 42, record.Response.PID)
+
+	_, err = registry.Wait(context.Background(), "unknown")
+	assert.Error(t, err)
+}
+
+func TestForgetFinished(t *testing.T) {
+	j := &Job{Registry: NewRegistry(), Logs: NewLogs(), MaxFinishedJobs: 2}
+	var ids []string
+	for i := 0; i < 4; i++ {
+		request := shared.DeployRequest{JobID: NewJobID()}
+		assert.NoError(t, j.Registry.Add(shared.JobRoleExecutor, shared.JobStatusRunning, request))
+		j.Logs.Append(request.JobID, "stdout", "hello")
+		ids = append(ids, request.JobID)
+	}
+	for _, id := range ids[:3] {
+		assert.NoError(t, j.Registry.Complete(shared.DeployResponse{JobID: id}))
+		time.Sleep(time.Millisecond)
+	}
+
+	// the oldest finished job goes, running ones stay whatever their number
+	j.ForgetFinished()
+	_, ok := j.Registry.Get(ids[0])
+	assert.False(t, ok)
+	_, _, _, err := j.ReadLogs(ids[0], 0)
+	assert.ErrorIs(t, err, shared.ErrJobNotFound)
+	assert.Len(t, j.Registry.List(), 3)
+	entries, _, _, err := j.ReadLogs(ids[1], 0)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// and so do those past their retention
+	j.JobRetention = time.Nanosecond
+	j.ForgetFinished()
+	records := j.Registry.List()
+	assert.Len(t, records, 1)
+	assert.Equal(t, ids[3], records[0].ID)
+	assert.NotContains(t, j.Logs.logs, ids[1])
+	assert.Contains(t, j.Logs.logs, ids[3])
+}
@@ -2,57 +2,419 @@ package job
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"nunet/app/executor"
 	"nunet/app/shared"
 	"nunet/pkg"
 )
 
-func (j *Job) PublishDeploymentRequest(ctx context.Context, request shared.DeployRequest) error {
-	requestBytes, err := json.Marshal(request)
+// SendDeploymentRequest assigns the request a job id, registers it and sends it to the target peer.
+// The response is collected in the background and recorded in the registry; if the target
+// can't run the job it is rescheduled on another peer, up to MaxRetries times.
+// Jobs whose program ran and failed are only rescheduled if the request asks for it.
+func (j *Job) SendDeploymentRequest(ctx context.Context, request shared.DeployRequest) (string, error) {
+	if request.JobID == "" {
+		request.JobID = NewJobID()
+	}
+
+	if _, err := peer.Decode(request.TargetPeerID); err != nil {
+		return "", fmt.Errorf("error decoding target peer id: %w", err)
+	}
+
+	if err := j.Registry.Add(shared.JobRoleSubmitter, shared.JobStatusSubmitted, request); err != nil {
+		return "", fmt.Errorf("error registering job: %w", err)
+	}
+
+	startedAt := time.Now()
+	stream, err := j.dispatch(ctx, request)
+	if err != nil && j.MaxRetries == 0 {
+		j.Registry.SetStatus(request.JobID, shared.JobStatusFailed)
+		j.Logs.Close(request.JobID)
+		j.DiscardInputs(request.JobID)
+		return "", err
+	}
+
+	go j.followJob(request, stream, err, startedAt)
+	return request.JobID, nil
+}
+
+// retryable reports whether an attempt's outcome is worth trying on another peer:
+// rejections and attempts that never got to run the program are, while a program
+// that ran and failed or timed out is only retried when the request asks for it
+func retryable(request shared.DeployRequest, response shared.DeployResponse) bool {
+	switch response.Status {
+	case shared.JobStatusSucceeded, shared.JobStatusCancelled:
+		return false
+	case shared.JobStatusRejected:
+		return true
+	}
+	return request.RetryFailed || !programRan(response)
+}
+
+// programRan reports whether the executor started the program, as opposed to failing
+// before it could (fetching inputs, pulling an image) or losing the connection first
+func programRan(response shared.DeployResponse) bool {
+	return response.ExitCode != nil || response.Usage != nil || response.PID != 0
+}
+
+// dispatch opens a job stream to the request's target peer and sends the request on it
+func (j *Job) dispatch(ctx context.Context, request shared.DeployRequest) (*jobStream, error) {
+	target, err := peer.Decode(request.TargetPeerID)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding target peer id: %w", err)
+	}
+
+	s, err := j.Host.NewStream(ctx, target, ProtocolID)
 	if err != nil {
-		return fmt.Errorf("error marshalling deployment request: %w", err)
+		return nil, fmt.Errorf("error opening stream to %s: %w", target, err)
 	}
 
-	if err := j.DeploymentTopic.Publish(ctx, requestBytes); err != nil {
-		return fmt.Errorf("error publishing deployment request: %w", err)
+	stream := newJobStream(s)
+	if err := stream.Send(Message{Type: MessageDeployRequest, Request: &request}); err != nil {
+		stream.Reset()
+		return nil, fmt.Errorf("error sending deployment request: %w", err)
 	}
 
-	fmt.Println("Deployment request sent")
-	return nil
+	fmt.Printf("Deployment request sent, job: %s, target: %s\n", request.JobID, target)
+	return stream, nil
+}
+
+// followJob waits for the outcome of each attempt at running the job, moving it to the
+// next best peer whenever an attempt is worth retrying and the retry budget allows it
+func (j *Job) followJob(request shared.DeployRequest, stream *jobStream, dispatchErr error, startedAt time.Time) {
+	tried := map[peer.ID]bool{}
+	for attempt := 1; ; attempt++ {
+		response := j.newSubmitterResponse(request, shared.JobStatusFailed, dispatchErr)
+		if dispatchErr == nil {
+			response = j.awaitDeploymentResponse(stream, request)
+		}
+
+		j.Registry.AddAttempt(request.JobID, shared.JobAttempt{
+			Attempt:      attempt,
+			TargetPeerID: request.TargetPeerID,
+			Status:       response.Status,
+			Err:          response.Err,
+			StartedAt:    startedAt,
+			EndedAt:      time.Now(),
+		})
+
+		if !retryable(request, response) || attempt > j.MaxRetries {
+			j.handleDeploymentResponse(response)
+			return
+		}
+		if j.cancelRequested(request.JobID) {
+			j.handleDeploymentResponse(j.newSubmitterResponse(request, shared.JobStatusCancelled, pkg.ErrCommandCancelled))
+			return
+		}
+
+		if target, err := peer.Decode(request.TargetPeerID); err == nil {
+			tried[target] = true
+		}
+		next, err := j.selectPeer(request.Runtime, request.Resources, tried)
+		if err != nil {
+			fmt.Printf("No other peer to retry job %s on: %s\n", request.JobID, err)
+			j.handleDeploymentResponse(response)
+			return
+		}
+
+		fmt.Printf("Job %s %s on %s, retrying on %s (retry %d of %d)\n",
+			request.JobID, response.Status, request.TargetPeerID, next, attempt, j.MaxRetries)
+		request.TargetPeerID = next.String()
+		if err := j.Registry.Retarget(request.JobID, request.TargetPeerID); err != nil {
+			fmt.Println("Error updating job registry:", err)
+		}
+
+		startedAt = time.Now()
+		stream, dispatchErr = j.dispatch(context.Background(), request)
+	}
 }
 
-// HandleDeploymentRequest processes incoming deployment requests
+// HandleDeploymentRequest serves deployment requests sent to this peer over the job protocol
 func (j *Job) HandleDeploymentRequest(ctx context.Context) {
+	j.setStreamHandler(ctx)
+	defer j.Host.RemoveStreamHandler(ProtocolID)
+
+	// Nothing is published on the deployment topic anymore; keep the subscription
+	// drained so broadcasts from older peers don't pile up.
 	for {
-		msg, err := j.DeploymentSub.Next(ctx)
-		if err != nil {
+		if _, err := j.DeploymentSub.Next(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
 			fmt.Println("Error reading message:", err)
-			continue
 		}
-		if j.Host.ID() == msg.GetFrom() { // Ignore messages from self
-			continue
+	}
+}
+
+// setStreamHandler registers the job protocol on the host
+func (j *Job) setStreamHandler(ctx context.Context) {
+	j.Host.SetStreamHandler(ProtocolID, func(s network.Stream) {
+		j.handleStream(ctx, newJobStream(s))
+	})
+}
+
+// handleStream runs the job received on the stream and replies with its result
+func (j *Job) handleStream(ctx context.Context, stream *jobStream) {
+	defer stream.Close()
+
+	stream.SetReadDeadline(time.Now().Add(requestReadTimeout))
+	msg, err := stream.Receive()
+	if err != nil {
+		fmt.Println("Error reading deployment request:", err)
+		stream.Reset()
+		return
+	}
+	stream.SetReadDeadline(time.Time{})
+	if msg.Type != MessageDeployRequest || msg.Request == nil {
+		fmt.Println("Unexpected message on job stream:", msg.Type)
+		stream.Reset()
+		return
+	}
+
+	request := *msg.Request
+	request.SourcePeerID = stream.Conn().RemotePeer().String() // trust the connection, not the payload
+	request.TargetPeerID = j.Host.ID().String()
+
+	if request.JobID == "" {
+		request.JobID = NewJobID()
+	}
+	// Any peer can send a request, so it's checked before the node keeps anything of it
+	if err := request.Validate(); err != nil {
+		response := rejectedResponse(j.newDeploymentResponse(request), &shared.Rejection{Reason: err.Error()})
+		if err := stream.Send(Message{Type: MessageDeployResponse, Response: &response}); err != nil {
+			fmt.Println("Error responding to deployment request:", err)
 		}
+		return
+	}
+	if err := j.Registry.Add(shared.JobRoleExecutor, shared.JobStatusSubmitted, request); err != nil {
+		fmt.Println("Error registering deployment request:", err)
+		stream.Reset()
+		return
+	}
+
+	response := j.newDeploymentResponse(request)
 
-		var request shared.DeployRequest
-		if err := json.Unmarshal(msg.GetData(), &request); err != nil {
-			fmt.Println("Error unmarshalling request:", err)
-			continue
+	// Let the submitter cancel the job while it waits or runs
+	jobCtx, cancelJob := context.WithCancel(ctx)
+	defer cancelJob()
+	j.running.Store(request.JobID, cancelJob)
+	defer j.running.Delete(request.JobID)
+	go j.watchForCancel(jobCtx, stream, request.JobID, cancelJob)
+
+	// Refuse runtimes, environment variables and programs this node doesn't allow
+	runner, err := j.Executors.Get(request.Runtime)
+	if err != nil {
+		j.rejectDeploymentRequest(stream, response, &shared.Rejection{Reason: err.Error()})
+		return
+	}
+	if rejection := j.EnvPolicy.Check(request.Env); rejection != nil {
+		j.rejectDeploymentRequest(stream, response, rejection)
+		return
+	}
+	if rejection := j.Policy.Admit(request.JobID, &request); rejection != nil {
+		j.rejectDeploymentRequest(stream, response, rejection)
+		return
+	}
+	defer j.Policy.Release(request.JobID)
+
+	// Push back when the node is already as busy as it's allowed to be
+	if err := j.Pool.Enqueue(); err != nil {
+		j.rejectDeploymentRequest(stream, response, &shared.Rejection{Reason: err.Error()})
+		return
+	}
+
+	// Refuse jobs this node can't satisfy
+	rejection, err := j.Admission.Reserve(request.JobID, request.Resources)
+	if err != nil {
+		rejection = &shared.Rejection{Reason: err.Error()}
+	}
+	if rejection != nil {
+		j.Pool.Dequeue()
+		j.rejectDeploymentRequest(stream, response, rejection)
+		return
+	}
+	defer j.Admission.Release(request.JobID)
+
+	// Wait for a free slot
+	j.reportStatus(stream, request.JobID, shared.JobStatusAccepted)
+	j.reportStatus(stream, request.JobID, shared.JobStatusQueued)
+	if err := j.Pool.Acquire(jobCtx); err != nil {
+		j.Pool.Dequeue()
+		if ctx.Err() != nil {
+			j.rejectDeploymentRequest(stream, response, &shared.Rejection{Reason: "node is shutting down"})
+			return
+		}
+		response.Status = shared.JobStatusCancelled
+		response.Err = pkg.ErrCommandCancelled.Error()
+		if err := j.sendDeploymentResponse(stream, response); err != nil {
+			fmt.Println("Error responding to deployment request:", err)
 		}
+		return
+	}
+	defer j.Pool.Release()
 
-		if request.TargetPeerID != j.Host.ID().String() { // Ignore messages not meant for this peer
-			fmt.Println("Received deployment request for another peer")
-			continue
+	workspace, err := j.Workspaces.Create(request)
+	if err == nil {
+		var releaseInputs func()
+		releaseInputs, err = j.fetchInputs(jobCtx, request, workspace)
+		defer releaseInputs()
+		if err != nil {
+			j.Workspaces.Release(workspace)
+		}
+	}
+	if err != nil {
+		fmt.Println("Error processing deployment request:", err)
+		response.Status = shared.JobStatusFailed
+		response.Err = err.Error()
+		if err := j.sendDeploymentResponse(stream, response); err != nil {
+			fmt.Println("Error responding to deployment request:", err)
 		}
+		return
+	}
+
+	runCtx, cancel := context.WithTimeout(jobCtx, j.Admission.Timeout(request.Resources))
+	defer cancel()
+
+	// Kill the job if it writes more than its workspace quota
+	var quotaExceeded atomic.Bool
+	go workspace.Enforce(runCtx, func() {
+		quotaExceeded.Store(true)
+		cancel()
+	})
 
-		output, pid, err := pkg.RunCmd(request.Program, request.Arguments...)
+	// Output is produced while the executor holds its own locks, so it's sent from elsewhere
+	logs := newLogSender(stream)
+	pulling := false
+	execution, err := runner.Start(runCtx, executor.Spec{
+		JobID:     request.JobID,
+		Workspace: workspace.Path,
+		Resources: request.Resources,
+		Command: pkg.Command{
+			Name:           request.Program,
+			Args:           request.Arguments,
+			Env:            jobEnv(request.Env, workspace),
+			Dir:            workspace.Dir,
+			Stdin:          strings.NewReader(request.Stdin),
+			MaxOutputBytes: maxOutputBytes(j.MaxOutputBytes),
+			OnOutput: func(outputStream string, text string) {
+				if entry, ok := j.Logs.Append(request.JobID, outputStream, text); ok {
+					logs.Send(Message{Type: MessageLog, Log: &entry})
+				}
+			},
+		},
+		OnPull: func(status string) {
+			if !pulling {
+				pulling = true
+				j.Registry.SetStatus(request.JobID, shared.JobStatusPulling)
+				logs.Report(Message{Type: MessageStatus, Status: shared.JobStatusPulling})
+			}
+			if entry, ok := j.Logs.Append(request.JobID, pkg.StreamPull, status); ok {
+				logs.Send(Message{Type: MessageLog, Log: &entry})
+			}
+		},
+	})
+	result := pkg.Result{ExitCode: -1}
+	if err == nil {
+		startedAt := time.Now()
+		j.Registry.SetStatus(request.JobID, shared.JobStatusRunning)
+		logs.Report(Message{Type: MessageStatus, Status: shared.JobStatusRunning}) // after the pull's progress
+		result, err = execution.Wait()
+		response.ElapsedMs = time.Since(startedAt).Milliseconds()
+	}
+	logs.Close()
+	if len(request.Artifacts) > 0 && result.Usage != nil {
+		artifacts, err := j.collectArtifacts(request, workspace)
 		if err != nil {
-			fmt.Println("Error processing deployment request:", err)
+			response.ArtifactsErr = err.Error()
 		}
+		response.Artifacts = artifacts
+	}
+	j.Workspaces.Release(workspace)
+	if quotaExceeded.Load() {
+		err = ErrQuotaExceeded
+	}
+	if err != nil {
+		fmt.Println("Error processing deployment request:", err)
+		response.Err = err.Error()
+	}
+	response.Status = runStatus(err)
+	response.PID = result.PID
+	response.Outputs = result.Lines
+	response.OutputTruncated = result.Truncated
+	response.Signal = result.Signal
+	response.OOMKilled = errors.Is(err, executor.ErrOutOfMemory)
+	response.Usage = result.Usage
+	if result.Usage != nil {
+		response.ExitCode = &result.ExitCode
+	}
 
-		if err := j.sendDeploymentResponse(ctx, request, pid, output, err); err != nil {
-			fmt.Println("Error responding to deployment request:", err)
+	if err := j.sendDeploymentResponse(stream, response); err != nil {
+		fmt.Println("Error responding to deployment request:", err)
+	}
+}
+
+// reportStatus moves a job run here to the status and lets the submitter know
+func (j *Job) reportStatus(stream *jobStream, id string, status shared.JobStatus) {
+	j.Registry.SetStatus(id, status)
+	if err := stream.Send(Message{Type: MessageStatus, Status: status}); err != nil {
+		fmt.Println("Error reporting job status:", err)
+	}
+}
+
+// watchForCancel stops the job when the submitter sends a cancel message on its stream, or
+// when the stream breaks: nobody would be left to collect the result
+func (j *Job) watchForCancel(ctx context.Context, stream *jobStream, jobID string, cancel context.CancelFunc) {
+	for {
+		msg, err := stream.Receive()
+		if err != nil {
+			if ctx.Err() == nil { // otherwise the stream was closed once the job finished
+				fmt.Printf("Lost the submitter of job %s, cancelling it: %v\n", jobID, err)
+			}
+			cancel()
+			return
 		}
+		if msg.Type == MessageCancel {
+			fmt.Println("Cancelling job:", jobID)
+			cancel()
+			return
+		}
+	}
+}
+
+// rejectDeploymentRequest tells the submitter this node won't run the job
+func (j *Job) rejectDeploymentRequest(stream *jobStream, response shared.DeployResponse, rejection *shared.Rejection) {
+	if err := j.sendDeploymentResponse(stream, rejectedResponse(response, rejection)); err != nil {
+		fmt.Println("Error responding to deployment request:", err)
+	}
+}
+
+// rejectedResponse fills in the response refusing a job
+func rejectedResponse(response shared.DeployResponse, rejection *shared.Rejection) shared.DeployResponse {
+	fmt.Printf("Rejecting job %s: %s\n", response.JobID, rejection.Error())
+	response.Status = shared.JobStatusRejected
+	response.Err = rejection.Error()
+	response.Rejection = rejection
+	return response
+}
+
+// runStatus maps the result of a command to the final job status
+func runStatus(err error) shared.JobStatus {
+	switch {
+	case err == nil:
+		return shared.JobStatusSucceeded
+	case errors.Is(err, pkg.ErrCommandTimeout):
+		return shared.JobStatusTimedOut
+	case errors.Is(err, pkg.ErrCommandCancelled):
+		return shared.JobStatusCancelled
+	default:
+		return shared.JobStatusFailed
 	}
 }
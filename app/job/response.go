@@ -1,77 +1,149 @@
 package job
 
 import (
-	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"os"
 	"strings"
+	"time"
 
 	"nunet/app/shared"
 )
 
-// SendDeploymentResponse sends a response to the deployment request
-func (j *Job) sendDeploymentResponse(
-	ctx context.Context,
-	request shared.DeployRequest,
-	pid int,
-	output []string,
-	err error,
-) error {
+// newDeploymentResponse returns a response to the request, to be filled in with the job's result
+func (j *Job) newDeploymentResponse(request shared.DeployRequest) shared.DeployResponse {
+	return shared.DeployResponse{
+		JobID:        request.JobID,
+		SourcePeerID: request.SourcePeerID,
+		SourceAddrs:  request.SourceAddrs,
+		Program:      request.Program,
+		Arguments:    request.Arguments,
+		TargetPeerID: request.TargetPeerID,
+		TargetAddrs:  j.listAddresses(),
+	}
+}
+
+// sendDeploymentResponse records the result of the job and sends it back on the stream it came from
+func (j *Job) sendDeploymentResponse(stream *jobStream, response shared.DeployResponse) error {
+	if err := j.Registry.Complete(response); err != nil {
+		fmt.Println("Error updating job registry:", err)
+	}
+	j.Logs.Close(response.JobID)
+
+	// a submitter that stopped reading mustn't keep the job's resources held
+	stream.SetWriteDeadline(time.Now().Add(responseSendTimeout))
+	if err := stream.Send(Message{Type: MessageDeployResponse, Response: &response}); err != nil {
+		return err
+	}
+
+	fmt.Println("Deployment response sent")
+	return nil
+}
+
+// newSubmitterResponse builds the response recorded when the target peer never answered
+func (j *Job) newSubmitterResponse(request shared.DeployRequest, status shared.JobStatus, err error) shared.DeployResponse {
 	var Err string
 	if err != nil {
 		Err = err.Error()
 	}
-	response := shared.DeployResponse{
+	return shared.DeployResponse{
+		JobID:        request.JobID,
+		Status:       status,
 		Err:          Err,
 		SourcePeerID: request.SourcePeerID,
 		SourceAddrs:  request.SourceAddrs,
 		Program:      request.Program,
 		Arguments:    request.Arguments,
-		PID:          pid,
 		TargetPeerID: request.TargetPeerID,
-		TargetAddrs:  request.SourceAddrs, // Check if this should be SourceAddrs or TargetAddrs
-		Outputs:      output,
 	}
+}
 
-	responseBytes, err := json.Marshal(response)
-	if err != nil {
-		return fmt.Errorf("error marshalling deployment response: %w", err)
+// responseTimeout is how long the submitter waits for the target peer to report back
+func (j *Job) responseTimeout(request shared.DeployRequest) time.Duration {
+	if request.Resources.MaxDuration > 0 {
+		return time.Duration(request.Resources.MaxDuration)*time.Second + responseGracePeriod
 	}
+	return j.ResponseTimeout
+}
 
-	if err := j.DeploymentResponseTopic.Publish(ctx, responseBytes); err != nil {
-		return fmt.Errorf("error publishing deployment response: %w", err)
+// awaitDeploymentResponse waits for the target peer to report back on the job stream
+func (j *Job) awaitDeploymentResponse(stream *jobStream, request shared.DeployRequest) shared.DeployResponse {
+	defer stream.Close()
+
+	j.streams.Store(request.JobID, stream)
+	defer j.streams.Delete(request.JobID)
+	if j.cancelRequested(request.JobID) { // cancelled while the stream was being opened
+		stream.Send(Message{Type: MessageCancel})
 	}
 
-	fmt.Println("Deployment response sent")
-	return nil
-}
+	timeout := j.responseTimeout(request)
+	if timeout > 0 {
+		stream.SetReadDeadline(time.Now().Add(timeout))
+	}
 
-func (j *Job) HandleDeploymentResponse(ctx context.Context) {
 	for {
-		msg, err := j.DeploymentResponseSub.Next(ctx)
+		msg, err := stream.Receive()
+		if isTimeout(err) {
+			// Stop the job rather than leave it running for nobody
+			stream.SetWriteDeadline(time.Now().Add(cancelSendTimeout))
+			stream.Send(Message{Type: MessageCancel})
+			stream.Reset()
+			return j.newSubmitterResponse(request, shared.JobStatusTimedOut,
+				fmt.Errorf("no response from target peer within %s", timeout))
+		}
 		if err != nil {
-			fmt.Println("Error reading message:", err)
-			continue
+			stream.Reset()
+			return j.newSubmitterResponse(request, shared.JobStatusFailed,
+				fmt.Errorf("lost connection to target peer: %w", err))
 		}
-		if j.Host.ID() == msg.GetFrom() { // Ignore messages from self
+
+		if msg.Type == MessageLog && msg.Log != nil {
+			j.Logs.AppendEntry(request.JobID, msg.Log)
 			continue
 		}
-
-		var response shared.DeployResponse
-		if err := json.Unmarshal(msg.GetData(), &response); err != nil {
-			fmt.Println("Error unmarshalling response:", err)
+		if msg.Type == MessageStatus {
+			if !msg.Status.IsTerminal() { // the final status comes with the response
+				j.Registry.SetStatus(request.JobID, msg.Status)
+			}
 			continue
 		}
-
-		if response.SourcePeerID != j.Host.ID().String() { // Ignore messages not meant for this peer
-			fmt.Println("Received deployment response for another peer")
+		if msg.Type != MessageDeployResponse || msg.Response == nil {
+			fmt.Println("Unexpected message on job stream:", msg.Type)
 			continue
 		}
 
-		if strings.TrimSpace(response.Err) == "" {
-			fmt.Printf("Deployment successful. PID: %d, %v \n", response.PID, strings.Join(response.Outputs, ","))
-		} else {
-			fmt.Printf("Deployment failed: %s\n", response.Err)
+		response := *msg.Response
+		response.JobID = request.JobID // the stream identifies the job
+		return response
+	}
+}
+
+// isTimeout tells whether reading the stream failed for hitting its deadline, which stream
+// multiplexers report with errors of their own
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// handleDeploymentResponse records the result reported by the peer that ran the job
+func (j *Job) handleDeploymentResponse(response shared.DeployResponse) {
+	j.cancelled.Delete(response.JobID)
+	if len(response.Artifacts) > 0 {
+		if err := j.fetchArtifacts(response); err != nil {
+			fmt.Println("Error fetching artifacts:", err)
+			response.ArtifactsErr = err.Error()
 		}
 	}
+	if err := j.Registry.Complete(response); err != nil {
+		fmt.Println("Error updating job registry:", err)
+	}
+	j.Logs.Close(response.JobID)
+	j.DiscardInputs(response.JobID)
+
+	if strings.TrimSpace(response.Err) == "" {
+		fmt.Printf("Deployment successful. Job: %s, PID: %d, %d lines of output\n", response.JobID, response.PID, len(response.Outputs))
+	} else {
+		fmt.Printf("Deployment failed. Job: %s, %s\n", response.JobID, response.Err)
+	}
 }
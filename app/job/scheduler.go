@@ -0,0 +1,178 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"nunet/app/executor"
+	"nunet/app/shared"
+	"nunet/pkg"
+)
+
+// Scheduling strategies understood by NewScheduler
+const (
+	StrategyLeastLoaded = "least-loaded" // most free capacity first
+	StrategyBinPacking  = "bin-packing"  // tightest fit first, keeping large peers free
+	StrategyRandom      = "random"
+	StrategyRoundRobin  = "round-robin"
+)
+
+// ErrNoCandidate is returned when no peer can take the job
+var ErrNoCandidate = errors.New("no peer can satisfy the job's resource requirements")
+
+// Candidate is a peer the scheduler may place a job on
+type Candidate struct {
+	PeerID    peer.ID
+	Compute   *pkg.AvailableCompute // nil when the peer hasn't advertised its resources
+	Executors []string              // nil when the peer hasn't advertised its runtimes
+}
+
+// supports reports whether the candidate can run jobs in the runtime. Peers that
+// haven't advertised their runtimes are given the benefit of the doubt.
+func (c Candidate) supports(runtime string) bool {
+	if c.Executors == nil {
+		return true
+	}
+	if runtime == "" {
+		runtime = executor.RuntimeProcess
+	}
+	for _, name := range c.Executors {
+		if name == runtime {
+			return true
+		}
+	}
+	return false
+}
+
+// fits reports whether the candidate has room for the job. Peers with unknown
+// resources are given the benefit of the doubt; they reject what they can't run.
+func (c Candidate) fits(resources shared.Resources) bool {
+	if c.Compute == nil {
+		return true
+	}
+	return c.Compute.FreeCPUCores >= resources.CPU &&
+		c.Compute.FreeRAM >= resources.RAM &&
+		c.Compute.FreeDisk >= resources.Disk
+}
+
+// ComputeLookup returns the resources last advertised by a peer, or nil if unknown
+type ComputeLookup func(id peer.ID) *pkg.AvailableCompute
+
+// ExecutorLookup returns the runtimes last advertised by a peer, or nil if unknown
+type ExecutorLookup func(id peer.ID) []string
+
+// Scheduler picks the peer a job should run on
+type Scheduler interface {
+	Select(candidates []Candidate, resources shared.Resources) (peer.ID, error)
+}
+
+// NewScheduler returns the scheduler implementing the named strategy
+func NewScheduler(strategy string) (Scheduler, error) {
+	switch strategy {
+	case StrategyLeastLoaded:
+		return rankingScheduler{less: moreFreeCapacity}, nil
+	case StrategyBinPacking:
+		return rankingScheduler{less: tighterFit}, nil
+	case StrategyRandom:
+		return randomScheduler{}, nil
+	case StrategyRoundRobin:
+		return &roundRobinScheduler{}, nil
+	default:
+		return nil, fmt.Errorf("unknown scheduling strategy %q", strategy)
+	}
+}
+
+// eligible returns the candidates with room for the job, ordered by peer id
+func eligible(candidates []Candidate, resources shared.Resources) []Candidate {
+	var results []Candidate
+	for _, candidate := range candidates {
+		if candidate.fits(resources) {
+			results = append(results, candidate)
+		}
+	}
+	sort.Slice(results, func(i, k int) bool {
+		return results[i].PeerID < results[k].PeerID
+	})
+	return results
+}
+
+// rankingScheduler picks the best candidate according to its ordering.
+// Peers with known resources always rank ahead of unknown ones.
+type rankingScheduler struct {
+	less func(a, b *pkg.AvailableCompute, resources shared.Resources) bool
+}
+
+func (s rankingScheduler) Select(candidates []Candidate, resources shared.Resources) (peer.ID, error) {
+	fitting := eligible(candidates, resources)
+	if len(fitting) == 0 {
+		return "", ErrNoCandidate
+	}
+
+	sort.SliceStable(fitting, func(i, k int) bool {
+		a, b := fitting[i].Compute, fitting[k].Compute
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		return s.less(a, b, resources)
+	})
+	return fitting[0].PeerID, nil
+}
+
+// freeShare is the average fraction of cpu and ram left free on a peer
+func freeShare(c *pkg.AvailableCompute) float64 {
+	var cpu, ram float64
+	if c.TotalCPUCores > 0 {
+		cpu = float64(c.FreeCPUCores) / float64(c.TotalCPUCores)
+	}
+	if c.TotalRAM > 0 {
+		ram = c.FreeRAM / c.TotalRAM
+	}
+	return (cpu + ram) / 2
+}
+
+func moreFreeCapacity(a, b *pkg.AvailableCompute, _ shared.Resources) bool {
+	return freeShare(a) > freeShare(b)
+}
+
+func tighterFit(a, b *pkg.AvailableCompute, resources shared.Resources) bool {
+	leftA, leftB := a.FreeRAM-resources.RAM, b.FreeRAM-resources.RAM
+	if leftA != leftB {
+		return leftA < leftB
+	}
+	return a.FreeCPUCores-resources.CPU < b.FreeCPUCores-resources.CPU
+}
+
+// randomScheduler spreads jobs uniformly across the peers that fit
+type randomScheduler struct{}
+
+func (randomScheduler) Select(candidates []Candidate, resources shared.Resources) (peer.ID, error) {
+	fitting := eligible(candidates, resources)
+	if len(fitting) == 0 {
+		return "", ErrNoCandidate
+	}
+	return fitting[rand.Intn(len(fitting))].PeerID, nil
+}
+
+// roundRobinScheduler cycles through the peers that fit, in peer id order
+type roundRobinScheduler struct {
+	mu   sync.Mutex
+	next int
+}
+
+func (s *roundRobinScheduler) Select(candidates []Candidate, resources shared.Resources) (peer.ID, error) {
+	fitting := eligible(candidates, resources)
+	if len(fitting) == 0 {
+		return "", ErrNoCandidate
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	selected := fitting[s.next%len(fitting)]
+	s.next++
+	return selected.PeerID, nil
+}
@@ -0,0 +1,97 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nunet/app/shared"
+	"nunet/pkg"
+)
+
+func testCandidates() []Candidate {
+	return []Candidate{
+		{PeerID: peer.ID("small"), Compute: &pkg.AvailableCompute{TotalCPUCores: 2, FreeCPUCores: 2, TotalRAM: 4, FreeRAM: 3}},
+		{PeerID: peer.ID("busy"), Compute: &pkg.AvailableCompute{TotalCPUCores: 16, FreeCPUCores: 2, TotalRAM: 64, FreeRAM: 8}},
+		{PeerID: peer.ID("large"), Compute: &pkg.AvailableCompute{TotalCPUCores: 16, FreeCPUCores: 14, TotalRAM: 64, FreeRAM: 60}},
+		{PeerID: peer.ID("unknown")},
+	}
+}
+
+func TestLeastLoadedScheduler(t *testing.T) {
+	scheduler, err := NewScheduler(StrategyLeastLoaded)
+	require.NoError(t, err)
+
+	selected, err := scheduler.Select(testCandidates(), shared.Resources{CPU: 1, RAM: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, peer.ID("large"), selected)
+
+	// a mostly idle small peer beats a large busy one
+	selected, err = scheduler.Select(testCandidates()[:2], shared.Resources{CPU: 1, RAM: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, peer.ID("small"), selected)
+
+	// only the peer with unknown resources is left
+	selected, err = scheduler.Select(testCandidates(), shared.Resources{CPU: 32})
+	assert.NoError(t, err)
+	assert.Equal(t, peer.ID("unknown"), selected)
+}
+
+func TestBinPackingScheduler(t *testing.T) {
+	scheduler, err := NewScheduler(StrategyBinPacking)
+	require.NoError(t, err)
+
+	selected, err := scheduler.Select(testCandidates(), shared.Resources{CPU: 1, RAM: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, peer.ID("small"), selected)
+
+	selected, err = scheduler.Select(testCandidates(), shared.Resources{CPU: 2, RAM: 6})
+	assert.NoError(t, err)
+	assert.Equal(t, peer.ID("busy"), selected)
+}
+
+func TestRoundRobinScheduler(t *testing.T) {
+	scheduler, err := NewScheduler(StrategyRoundRobin)
+	require.NoError(t, err)
+
+	var selected []peer.ID
+	for i := 0; i < 5; i++ {
+		id, err := scheduler.Select(testCandidates(), shared.Resources{})
+		require.NoError(t, err)
+		selected = append(selected, id)
+	}
+	assert.Equal(t, []peer.ID{"busy", "large", "small", "unknown", "busy"}, selected)
+}
+
+func TestRandomScheduler(t *testing.T) {
+	scheduler, err := NewScheduler(StrategyRandom)
+	require.NoError(t, err)
+
+	candidates := testCandidates()[:3]
+	selected, err := scheduler.Select(candidates, shared.Resources{CPU: 8})
+	assert.NoError(t, err)
+	assert.Equal(t, peer.ID("large"), selected)
+
+	_, err = scheduler.Select(candidates, shared.Resources{RAM: 128})
+	assert.ErrorIs(t, err, ErrNoCandidate)
+}
+
+func TestUnknownStrategy(t *testing.T) {
+	_, err := NewScheduler("fastest")
+	assert.Error(t, err)
+}
+
+func TestCandidateSupports(t *testing.T) {
+	unknown := Candidate{PeerID: peer.ID("unknown")}
+	assert.True(t, unknown.supports("wasm"))
+
+	process := Candidate{PeerID: peer.ID("process"), Executors: []string{"process"}}
+	assert.True(t, process.supports(""))
+	assert.True(t, process.supports("process"))
+	assert.False(t, process.supports("wasm"))
+
+	none := Candidate{PeerID: peer.ID("none"), Executors: []string{}}
+	assert.False(t, none.supports(""))
+}
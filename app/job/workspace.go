@@ -0,0 +1,154 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"nunet/app/shared"
+)
+
+// ErrQuotaExceeded is reported when a job writes more to its workspace than it may
+var ErrQuotaExceeded = errors.New("workspace disk quota exceeded")
+
+// quotaCheckInterval is how often the size of a running job's workspace is measured
+const quotaCheckInterval = time.Second
+
+// Workspaces gives every job run on this node a private directory of its own
+type Workspaces struct {
+	Root      string
+	Quota     int64         // bytes a job may write when it requests no disk, unlimited if 0
+	Retention time.Duration // how long a workspace is kept once its job ends, for debugging
+}
+
+// NewWorkspaces creates the root workspaces are created in
+func NewWorkspaces(root string, quota int64, retention time.Duration) (*Workspaces, error) {
+	if err := os.MkdirAll(root, 0o700); err != nil {
+		return nil, fmt.Errorf("error creating workspace root: %w", err)
+	}
+	return &Workspaces{
+		Root:      root,
+		Quota:     quota,
+		Retention: retention,
+	}, nil
+}
+
+// Workspace is the directory a job runs in
+type Workspace struct {
+	Path  string // root of the workspace, named after the job
+	Dir   string // the job's working directory, inside Path
+	Quota int64  // bytes the job may write, unlimited if 0
+}
+
+// Create makes a fresh workspace for the job
+func (w *Workspaces) Create(request shared.DeployRequest) (*Workspace, error) {
+	if err := shared.ValidateJobID(request.JobID); err != nil {
+		return nil, err
+	}
+	if err := shared.ValidateWorkDir(request.WorkDir); err != nil {
+		return nil, err
+	}
+
+	workspace := &Workspace{
+		Path:  filepath.Join(w.Root, request.JobID),
+		Quota: w.Quota,
+	}
+	if request.Resources.Disk > 0 {
+		workspace.Quota = int64(request.Resources.Disk * (1 << 30))
+	}
+	workspace.Dir = filepath.Join(workspace.Path, request.WorkDir)
+
+	// a leftover workspace of the same job must not leak into this run
+	if err := os.RemoveAll(workspace.Path); err != nil {
+		return nil, fmt.Errorf("error clearing workspace: %w", err)
+	}
+	for _, dir := range []string{workspace.Dir, workspace.TempDir()} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("error creating workspace: %w", err)
+		}
+	}
+	return workspace, nil
+}
+
+// Release removes the workspace, straight away or once the retention period is over
+func (w *Workspaces) Release(workspace *Workspace) {
+	if w.Retention <= 0 {
+		removeWorkspace(workspace.Path)
+		return
+	}
+	time.AfterFunc(w.Retention, func() { removeWorkspace(workspace.Path) })
+}
+
+// Sweep removes workspaces left behind for longer than the retention period,
+// such as the ones of jobs running when the node last stopped
+func (w *Workspaces) Sweep() {
+	entries, err := os.ReadDir(w.Root)
+	if err != nil {
+		fmt.Println("Error listing workspaces:", err)
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !entry.IsDir() || time.Since(info.ModTime()) < w.Retention {
+			continue
+		}
+		removeWorkspace(filepath.Join(w.Root, entry.Name()))
+	}
+}
+
+func removeWorkspace(path string) {
+	if err := os.RemoveAll(path); err != nil {
+		fmt.Println("Error removing workspace:", err)
+	}
+}
+
+// TempDir is the directory the job is given for temporary files
+func (ws *Workspace) TempDir() string {
+	return filepath.Join(ws.Path, ".tmp")
+}
+
+// Enforce measures the workspace until ctx is done and calls exceeded once the job
+// has written more than its quota. The quota is a soft limit: the workspace is only
+// measured every quotaCheckInterval, so a job can write past it, as far as the volume
+// lets it, before it's killed. Put WORKSPACE_DIR on a volume of its own, or one with
+// filesystem quotas, where that matters.
+func (ws *Workspace) Enforce(ctx context.Context, exceeded func()) {
+	if ws.Quota <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(quotaCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if size, err := dirSize(ws.Path); err == nil && size > ws.Quota {
+			exceeded()
+			return
+		}
+	}
+}
+
+// dirSize returns the bytes taken by the regular files under path
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // the job may be removing files as we go
+		}
+		if entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,91 @@
+package job
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nunet/app/shared"
+)
+
+func TestWorkspaceLifecycle(t *testing.T) {
+	workspaces, err := NewWorkspaces(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+
+	workspace, err := workspaces.Create(shared.DeployRequest{JobID: "job-1", WorkDir: "src"})
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(workspaces.Root, "job-1"), workspace.Path)
+	assert.DirExists(t, filepath.Join(workspace.Path, "src"))
+	assert.DirExists(t, workspace.TempDir())
+
+	workspaces.Release(workspace)
+	assert.NoDirExists(t, workspace.Path)
+
+	_, err = workspaces.Create(shared.DeployRequest{JobID: "../job-2"})
+	assert.Error(t, err)
+
+	// "." names the root itself, which holds every other job's workspace
+	other, err := workspaces.Create(shared.DeployRequest{JobID: "other"})
+	require.NoError(t, err)
+	_, err = workspaces.Create(shared.DeployRequest{JobID: "."})
+	assert.Error(t, err)
+	assert.DirExists(t, other.Path)
+	_, err = workspaces.Create(shared.DeployRequest{JobID: "job-3", WorkDir: "../../etc"})
+	assert.Error(t, err)
+}
+
+func TestWorkspaceRetention(t *testing.T) {
+	workspaces, err := NewWorkspaces(t.TempDir(), 0, 100*time.Millisecond)
+	require.NoError(t, err)
+
+	workspace, err := workspaces.Create(shared.DeployRequest{JobID: "job-1"})
+	require.NoError(t, err)
+	workspaces.Release(workspace)
+	assert.DirExists(t, workspace.Path)
+
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(workspace.Path)
+		return os.IsNotExist(err)
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestWorkspaceSweep(t *testing.T) {
+	workspaces, err := NewWorkspaces(t.TempDir(), 0, time.Hour)
+	require.NoError(t, err)
+
+	stale, err := workspaces.Create(shared.DeployRequest{JobID: "stale"})
+	require.NoError(t, err)
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(stale.Path, old, old))
+	fresh, err := workspaces.Create(shared.DeployRequest{JobID: "fresh"})
+	require.NoError(t, err)
+
+	workspaces.Sweep()
+	assert.NoDirExists(t, stale.Path)
+	assert.DirExists(t, fresh.Path)
+}
+
+func TestWorkspaceQuota(t *testing.T) {
+	workspaces, err := NewWorkspaces(t.TempDir(), 1024, 0)
+	require.NoError(t, err)
+
+	workspace, err := workspaces.Create(shared.DeployRequest{JobID: "job-1"})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(workspace.Dir, "big"), make([]byte, 2048), 0o600))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	exceeded := false
+	workspace.Enforce(ctx, func() { exceeded = true })
+	assert.True(t, exceeded)
+
+	// jobs asking for disk get that much instead
+	workspace, err = workspaces.Create(shared.DeployRequest{JobID: "job-2", Resources: shared.Resources{Disk: 1}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1<<30), workspace.Quota)
+}
@@ -0,0 +1,8 @@
+//go:build linux && arm64
+
+package sandbox
+
+import "golang.org/x/sys/unix"
+
+// auditArch identifies the architecture seccomp filters are written for
+const auditArch = unix.AUDIT_ARCH_AARCH64
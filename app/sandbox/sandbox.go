@@ -0,0 +1,31 @@
+// Package sandbox confines the processes jobs run as. On Linux each process gets its own
+// user, pid, mount and network namespaces, sees the node's filesystem read-only apart from
+// its workspace, with the directories holding other jobs' files emptied, runs without
+// capabilities and, optionally, is refused system calls that would reach beyond the sandbox.
+package sandbox
+
+import "errors"
+
+// ErrUnsupported is returned where processes can't be sandboxed
+var ErrUnsupported = errors.New("sandboxing is only supported on linux")
+
+// Policy is how a node confines processes
+type Policy struct {
+	Network bool `json:"network"` // share the node's network, processes only get a loopback interface otherwise
+	Seccomp bool `json:"seccomp"` // refuse system calls such as mount, ptrace or unshare
+
+	// Hidden directories appear empty in the sandbox, such as where the node keeps the
+	// workspaces and files of other jobs. A process's own workspace stays in place.
+	Hidden []string `json:"hidden,omitempty"`
+}
+
+// envKey passes the setup to the helper process applying it
+const envKey = "NUNET_SANDBOX"
+
+// setup is what the helper process does before starting the sandboxed program
+type setup struct {
+	Policy
+	Writable string `json:"writable"` // directory left writable, the workspace of the program
+	Dir      string `json:"dir"`      // working directory of the program, inside Writable
+	Probe    bool   `json:"probe"`    // exit once set up instead of starting a program
+}
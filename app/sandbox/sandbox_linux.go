@@ -0,0 +1,336 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// selfExe is the node's own binary, started in place of sandboxed programs to set up the sandbox
+const selfExe = "/proc/self/exe"
+
+// Securebits keeping root in the sandbox from regaining capabilities when it starts a program
+const (
+	secbitNoRoot = 1 << iota
+	secbitNoRootLocked
+	secbitNoSetuidFixup
+	secbitNoSetuidFixupLocked
+	secbitKeepCaps
+	secbitKeepCapsLocked
+)
+
+// Init sets up the sandbox and starts the sandboxed program when the node's binary was started
+// by Apply, and returns straight away otherwise. It must be the first thing main does.
+func Init() {
+	encoded, ok := os.LookupEnv(envKey)
+	if !ok {
+		return
+	}
+	os.Unsetenv(envKey)
+
+	// Capabilities, securebits and seccomp filters belong to a thread, exec keeps this one's
+	runtime.LockOSThread()
+
+	var s setup
+	if err := json.Unmarshal([]byte(encoded), &s); err != nil {
+		fail(fmt.Errorf("error reading setup: %w", err))
+	}
+	if err := s.apply(); err != nil {
+		fail(err)
+	}
+	if s.Probe {
+		os.Exit(0)
+	}
+
+	path, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		fail(err)
+	}
+	err = syscall.Exec(path, os.Args, os.Environ())
+	fail(fmt.Errorf("error starting %s: %w", os.Args[0], err))
+}
+
+// fail reports why the sandbox couldn't be set up and exits like a shell not finding a program
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "sandbox:", err)
+	os.Exit(127)
+}
+
+// Check starts a sandbox that exits once it's set up, to tell whether the node can sandbox processes
+func Check(policy Policy) error {
+	cmd := exec.Command(selfExe)
+	policy.Hidden = hiddenDirs(policy)
+	if err := start(cmd, setup{Policy: policy, Probe: true}); err != nil {
+		return err
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Apply makes the command start sandboxed, with the writable directory, its workspace, left
+// writable; the command's working directory must be inside it and is used if it's empty. The
+// node's binary is started in new namespaces in its place and starts the program once it's confined.
+func Apply(cmd *exec.Cmd, policy Policy, writable string) error {
+	s := setup{Policy: policy}
+	if cmd.Dir != "" {
+		dir, err := resolve(cmd.Dir)
+		if err != nil {
+			return fmt.Errorf("error resolving working directory: %w", err)
+		}
+		s.Dir, s.Writable = dir, dir
+	}
+	if writable != "" {
+		dir, err := resolve(writable)
+		if err != nil {
+			return fmt.Errorf("error resolving workspace: %w", err)
+		}
+		if s.Dir != "" && !within(s.Dir, dir) {
+			return fmt.Errorf("working directory %s is outside the workspace %s", s.Dir, dir)
+		}
+		s.Writable = dir
+	}
+	s.Hidden = hiddenDirs(policy)
+	return start(cmd, s)
+}
+
+// hiddenDirs resolves the directories the policy hides; those that don't exist have nothing to hide
+func hiddenDirs(policy Policy) []string {
+	var dirs []string
+	for _, hidden := range policy.Hidden {
+		if dir, err := resolve(hidden); err == nil {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// resolve returns the absolute path of the directory, with its links followed
+func resolve(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(dir)
+}
+
+// within tells whether path is dir or inside it
+func within(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// start makes the command start the node's binary in new namespaces, to apply the setup
+func start(cmd *exec.Cmd, s setup) error {
+	encoded, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	cmd.Path = selfExe
+	cmd.Env = append(cmd.Environ(), envKey+"="+string(encoded))
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	attr := cmd.SysProcAttr
+	attr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+	if !s.Network {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	// The node's user is root in the sandbox, and nobody else exists there
+	attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+	attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	return nil
+}
+
+// apply confines the process, which must be the first of its namespaces
+func (s setup) apply() error {
+	// Mounts changed from here on are only seen in the sandbox
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("error making mounts private: %w", err)
+	}
+	// Held open so the workspace can be mounted back once a hidden directory covers it
+	var writable *os.File
+	if s.Writable != "" {
+		var err error
+		if writable, err = os.OpenFile(s.Writable, unix.O_PATH|unix.O_DIRECTORY, 0); err != nil {
+			return fmt.Errorf("error opening %s: %w", s.Writable, err)
+		}
+		defer writable.Close()
+	}
+	if err := unix.Mount("proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("error mounting /proc: %w", err)
+	}
+	for _, hidden := range s.Hidden {
+		if err := unix.Mount("tmpfs", hidden, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "mode=755,size=4k"); err != nil {
+			return fmt.Errorf("error hiding %s: %w", hidden, err)
+		}
+	}
+	if writable != nil {
+		if err := os.MkdirAll(s.Writable, 0o700); err != nil {
+			return fmt.Errorf("error mounting %s: %w", s.Writable, err)
+		}
+		source := fmt.Sprintf("/proc/self/fd/%d", writable.Fd())
+		if err := unix.Mount(source, s.Writable, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return fmt.Errorf("error mounting %s: %w", s.Writable, err)
+		}
+	}
+	if s.Dir != "" {
+		// The working directory was entered before the mounts, so it's still the one underneath
+		if err := os.Chdir(s.Dir); err != nil {
+			return err
+		}
+	}
+	if err := remountReadOnly(s.Writable); err != nil {
+		return err
+	}
+	if !s.Network {
+		if err := loopbackUp(); err != nil {
+			return fmt.Errorf("error setting up loopback: %w", err)
+		}
+	}
+
+	if err := dropCapabilities(); err != nil {
+		return fmt.Errorf("error dropping capabilities: %w", err)
+	}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("error setting no_new_privs: %w", err)
+	}
+	if s.Seccomp {
+		if err := installSeccomp(); err != nil {
+			return fmt.Errorf("error installing seccomp filter: %w", err)
+		}
+	}
+	return nil
+}
+
+// mountFlags are the flags of a mount, as reported by statfs, that remounting it must keep
+var mountFlags = map[int64]uintptr{
+	unix.ST_NOSUID:     unix.MS_NOSUID,
+	unix.ST_NODEV:      unix.MS_NODEV,
+	unix.ST_NOEXEC:     unix.MS_NOEXEC,
+	unix.ST_NOATIME:    unix.MS_NOATIME,
+	unix.ST_NODIRATIME: unix.MS_NODIRATIME,
+	unix.ST_RELATIME:   unix.MS_RELATIME,
+}
+
+// remountReadOnly makes every mount read-only, except the writable directory and those under it
+func remountReadOnly(writable string) error {
+	mountPoints, err := readMountPoints()
+	if err != nil {
+		return err
+	}
+	for _, mountPoint := range mountPoints {
+		if writable != "" && within(mountPoint, writable) {
+			continue
+		}
+
+		var stat unix.Statfs_t
+		err := unix.Statfs(mountPoint, &stat)
+		if err == nil {
+			// Flags set outside the sandbox are locked, leaving them out would fail the remount
+			flags := uintptr(unix.MS_BIND | unix.MS_REMOUNT | unix.MS_RDONLY)
+			for statFlag, mountFlag := range mountFlags {
+				if int64(stat.Flags)&statFlag != 0 {
+					flags |= mountFlag
+				}
+			}
+			err = unix.Mount("", mountPoint, "", flags, "")
+		}
+		// Mounts the sandbox can't reach don't need to be made read-only
+		if err != nil && !errors.Is(err, unix.ENOENT) && !errors.Is(err, unix.EACCES) {
+			return fmt.Errorf("error making %s read-only: %w", mountPoint, err)
+		}
+	}
+	return nil
+}
+
+// readMountPoints lists the mounts of the process's mount namespace
+func readMountPoints() ([]string, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var mountPoints []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mountPoints = append(mountPoints, unescapeMountPoint(fields[4]))
+	}
+	return mountPoints, scanner.Err()
+}
+
+// unescapeMountPoint decodes the octal escapes mountinfo writes spaces and the like as
+func unescapeMountPoint(field string) string {
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+3 < len(field) {
+			if c, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(field[i])
+	}
+	return b.String()
+}
+
+// loopbackUp brings up the loopback interface of the sandbox's network
+func loopbackUp() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	ifreq, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifreq); err != nil {
+		return err
+	}
+	ifreq.SetUint16(ifreq.Uint16() | unix.IFF_UP)
+	return unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifreq)
+}
+
+// dropCapabilities leaves the thread without capabilities, for good
+func dropCapabilities() error {
+	bits := secbitNoRoot | secbitNoRootLocked | secbitNoSetuidFixup | secbitNoSetuidFixupLocked | secbitKeepCapsLocked
+	if err := unix.Prctl(unix.PR_SET_SECUREBITS, uintptr(bits), 0, 0, 0); err != nil {
+		return err
+	}
+	// The kernel refuses capabilities past the last one it knows
+	for capability := 0; ; capability++ {
+		err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(capability), 0, 0, 0)
+		if errors.Is(err, unix.EINVAL) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return err
+	}
+	var data [2]unix.CapUserData
+	return unix.Capset(&unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}, &data[0])
+}
@@ -0,0 +1,137 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nunet/pkg"
+)
+
+// TestMain lets the test binary act as the sandbox helper, as the node's binary does
+func TestMain(m *testing.M) {
+	Init()
+	os.Exit(m.Run())
+}
+
+// runSandboxed runs the shell script sandboxed in dir and returns its output
+func runSandboxed(t *testing.T, policy Policy, dir, script string) (string, error) {
+	result, err := pkg.RunCmd(context.Background(), pkg.Command{
+		Name:  "sh",
+		Args:  []string{"-c", script},
+		Dir:   dir,
+		Setup: func(cmd *exec.Cmd) error { return Apply(cmd, policy, "") },
+	})
+	var lines []string
+	for _, line := range result.Lines {
+		lines = append(lines, line.Text)
+	}
+	return strings.Join(lines, "\n"), err
+}
+
+func requireSandbox(t *testing.T, policy Policy) {
+	if err := Check(policy); err != nil {
+		t.Skip("sandboxing isn't available:", err)
+	}
+}
+
+func TestSandboxFilesystem(t *testing.T) {
+	policy := Policy{Seccomp: true}
+	requireSandbox(t, policy)
+	workspace, outside := t.TempDir(), t.TempDir()
+
+	output, err := runSandboxed(t, policy, workspace, "echo $$; id -u; echo hi > out; touch "+outside+"/escaped")
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(output, "1\n0\n"), output) // the shell is the first process and root in its sandbox
+	assert.Contains(t, output, "Read-only file system")
+
+	data, err := os.ReadFile(filepath.Join(workspace, "out"))
+	require.NoError(t, err)
+	assert.Equal(t, "hi\n", string(data))
+	assert.NoFileExists(t, filepath.Join(outside, "escaped"))
+}
+
+func TestSandboxHidden(t *testing.T) {
+	base := t.TempDir()
+	workspaces, blobs := filepath.Join(base, "jobs"), filepath.Join(base, "blobs")
+	policy := Policy{Hidden: []string{workspaces, blobs, filepath.Join(base, "missing")}}
+	requireSandbox(t, policy)
+
+	workspace, dir := filepath.Join(workspaces, "mine"), filepath.Join(workspaces, "mine", "work")
+	require.NoError(t, os.MkdirAll(dir, 0o700))
+	require.NoError(t, os.MkdirAll(filepath.Join(workspaces, "other"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(workspaces, "other", "secret"), []byte("secret"), 0o600))
+	require.NoError(t, os.MkdirAll(blobs, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(blobs, "blob"), []byte("blob"), 0o600))
+
+	result, err := pkg.RunCmd(context.Background(), pkg.Command{
+		Name:  "sh",
+		Args:  []string{"-c", "pwd; echo tmp > ../tmp.txt; ls " + workspaces + "; ls " + blobs + "; cat " + workspaces + "/other/secret"},
+		Dir:   dir,
+		Setup: func(cmd *exec.Cmd) error { return Apply(cmd, policy, workspace) },
+	})
+	assert.Error(t, err) // the secret isn't there to read
+	var lines []string
+	for _, line := range result.Lines {
+		lines = append(lines, line.Text)
+	}
+	require.GreaterOrEqual(t, len(lines), 2, lines)
+	assert.Equal(t, []string{dir, "mine"}, lines[:2]) // only its own workspace, and no blobs
+	assert.NotContains(t, strings.Join(lines, "\n"), "secret\n")
+
+	// the whole workspace is writable, not only the working directory
+	data, err := os.ReadFile(filepath.Join(workspace, "tmp.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "tmp\n", string(data))
+	assert.FileExists(t, filepath.Join(workspaces, "other", "secret"))
+
+	outside := exec.Command("true")
+	outside.Dir = t.TempDir()
+	assert.ErrorContains(t, Apply(outside, policy, workspace), "outside the workspace")
+}
+
+func TestSandboxNetwork(t *testing.T) {
+	requireSandbox(t, Policy{})
+	host, err := os.Readlink("/proc/self/ns/net")
+	require.NoError(t, err)
+
+	output, err := runSandboxed(t, Policy{}, t.TempDir(), "readlink /proc/self/ns/net")
+	require.NoError(t, err)
+	assert.NotEqual(t, host, output)
+
+	output, err = runSandboxed(t, Policy{Network: true}, t.TempDir(), "readlink /proc/self/ns/net")
+	require.NoError(t, err)
+	assert.Equal(t, host, output)
+}
+
+func TestSandboxSeccomp(t *testing.T) {
+	if _, err := exec.LookPath("unshare"); err != nil {
+		t.Skip("unshare isn't installed")
+	}
+	requireSandbox(t, Policy{Seccomp: true})
+
+	// Without capabilities a process may still make a user namespace, unless seccomp refuses it
+	_, err := runSandboxed(t, Policy{}, t.TempDir(), "unshare --user true")
+	assert.NoError(t, err)
+	output, err := runSandboxed(t, Policy{Seccomp: true}, t.TempDir(), "unshare --user true")
+	assert.Error(t, err)
+	assert.Contains(t, output, "Operation not permitted")
+}
+
+func TestSandboxMissingProgram(t *testing.T) {
+	requireSandbox(t, Policy{})
+	result, err := pkg.RunCmd(context.Background(), pkg.Command{
+		Name:  "/nunet-no-such-program",
+		Setup: func(cmd *exec.Cmd) error { return Apply(cmd, Policy{}, "") },
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 127, result.ExitCode)
+}
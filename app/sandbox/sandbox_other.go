@@ -0,0 +1,18 @@
+//go:build !linux
+
+package sandbox
+
+import "os/exec"
+
+// Init does nothing, processes aren't sandboxed on this platform
+func Init() {}
+
+// Check returns ErrUnsupported
+func Check(policy Policy) error {
+	return ErrUnsupported
+}
+
+// Apply returns ErrUnsupported
+func Apply(cmd *exec.Cmd, policy Policy, writable string) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,115 @@
+//go:build linux && (amd64 || arm64)
+
+package sandbox
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Offsets of the fields of struct seccomp_data filters read
+const (
+	seccompNr   = 0
+	seccompArch = 4
+	seccompArg0 = 16 // lower half on little endian architectures
+)
+
+// x32SyscallBit marks system calls of the x32 ABI, whose numbers the filter doesn't know
+const x32SyscallBit = 0x40000000
+
+// namespaceFlags are the clone flags creating namespaces, which would let a process undo its sandbox
+const namespaceFlags = unix.CLONE_NEWUSER | unix.CLONE_NEWNS | unix.CLONE_NEWPID | unix.CLONE_NEWNET |
+	unix.CLONE_NEWIPC | unix.CLONE_NEWUTS | unix.CLONE_NEWCGROUP
+
+// deniedSyscalls reach beyond the sandbox: they change mounts, namespaces, keys, the clock
+// or the kernel, or look into other processes
+var deniedSyscalls = []uint32{
+	unix.SYS_ACCT,
+	unix.SYS_ADD_KEY,
+	unix.SYS_BPF,
+	unix.SYS_CHROOT,
+	unix.SYS_DELETE_MODULE,
+	unix.SYS_FINIT_MODULE,
+	unix.SYS_FSCONFIG,
+	unix.SYS_FSMOUNT,
+	unix.SYS_FSOPEN,
+	unix.SYS_FSPICK,
+	unix.SYS_INIT_MODULE,
+	unix.SYS_KEXEC_FILE_LOAD,
+	unix.SYS_KEXEC_LOAD,
+	unix.SYS_KEYCTL,
+	unix.SYS_MOUNT,
+	unix.SYS_MOUNT_SETATTR,
+	unix.SYS_MOVE_MOUNT,
+	unix.SYS_NAME_TO_HANDLE_AT,
+	unix.SYS_OPEN_BY_HANDLE_AT,
+	unix.SYS_OPEN_TREE,
+	unix.SYS_PERF_EVENT_OPEN,
+	unix.SYS_PIVOT_ROOT,
+	unix.SYS_PROCESS_VM_READV,
+	unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_PTRACE,
+	unix.SYS_QUOTACTL,
+	unix.SYS_REBOOT,
+	unix.SYS_REQUEST_KEY,
+	unix.SYS_SETNS,
+	unix.SYS_SETTIMEOFDAY,
+	unix.SYS_SWAPOFF,
+	unix.SYS_SWAPON,
+	unix.SYS_SYSLOG,
+	unix.SYS_UMOUNT2,
+	unix.SYS_UNSHARE,
+	unix.SYS_USERFAULTFD,
+}
+
+// installSeccomp makes the thread, and the programs it starts, fail denied system calls with EPERM
+func installSeccomp() error {
+	filter := seccompFilter()
+	program := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	return unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&program)), 0, 0)
+}
+
+// seccompFilter returns the BPF program checking each system call
+func seccompFilter() []unix.SockFilter {
+	stmt := func(code uint16, k uint32) unix.SockFilter {
+		return unix.SockFilter{Code: code, K: k}
+	}
+	jump := func(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+		return unix.SockFilter{Code: code, K: k, Jt: jt, Jf: jf}
+	}
+	const (
+		load  = unix.BPF_LD | unix.BPF_W | unix.BPF_ABS
+		ret   = unix.BPF_RET | unix.BPF_K
+		jeq   = unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K
+		jge   = unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K
+		jset  = unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K
+		deny  = unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)
+		allow = unix.SECCOMP_RET_ALLOW
+	)
+
+	filter := []unix.SockFilter{
+		// System call numbers differ between architectures, calls made for another one are fatal
+		stmt(load, seccompArch),
+		jump(jeq, auditArch, 1, 0),
+		stmt(ret, unix.SECCOMP_RET_KILL_PROCESS),
+		stmt(load, seccompNr),
+		jump(jge, x32SyscallBit, 0, 1),
+		stmt(ret, deny),
+	}
+	for _, nr := range deniedSyscalls {
+		filter = append(filter, jump(jeq, nr, 0, 1), stmt(ret, deny))
+	}
+	// clone3 passes its flags in memory filters can't read; refusing it as unimplemented makes
+	// libc fall back to clone, whose flags are checked
+	filter = append(filter,
+		jump(jeq, unix.SYS_CLONE3, 0, 1),
+		stmt(ret, unix.SECCOMP_RET_ERRNO|uint32(unix.ENOSYS)),
+		jump(jeq, unix.SYS_CLONE, 0, 3),
+		stmt(load, seccompArg0),
+		jump(jset, namespaceFlags, 0, 1),
+		stmt(ret, deny),
+		stmt(ret, allow),
+	)
+	return filter
+}
@@ -0,0 +1,13 @@
+//go:build linux && !amd64 && !arm64
+
+package sandbox
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// installSeccomp fails, filters are only written for amd64 and arm64
+func installSeccomp() error {
+	return fmt.Errorf("seccomp filters aren't supported on %s", runtime.GOARCH)
+}
@@ -1,17 +1,262 @@
 package shared
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/ipfs/go-cid"
+
+	"nunet/pkg"
+)
+
+var (
+	ErrJobNotFound   = errors.New("job not found")
+	ErrJobFinished   = errors.New("job already finished")
+	ErrFilesTooLarge = errors.New("files are too large")
+	ErrInvalidFile   = errors.New("invalid file")
+	ErrNoArtifact    = errors.New("artifact not found")
+)
+
+// JobStatus describes where a job is in its lifecycle
+type JobStatus string
+
+const (
+	JobStatusSubmitted JobStatus = "submitted"
+	JobStatusAccepted  JobStatus = "accepted"
+	JobStatusQueued    JobStatus = "queued"
+	JobStatusPulling   JobStatus = "pulling" // fetching the image the job runs in
+	JobStatusRunning   JobStatus = "running"
+	JobStatusSucceeded JobStatus = "succeeded"
+	JobStatusFailed    JobStatus = "failed"
+	JobStatusTimedOut  JobStatus = "timed_out"
+	JobStatusRejected  JobStatus = "rejected"
+	JobStatusCancelled JobStatus = "cancelled"
+)
+
+// IsTerminal reports whether no further transitions are expected from the status
+func (s JobStatus) IsTerminal() bool {
+	switch s {
+	case JobStatusSucceeded, JobStatusFailed, JobStatusTimedOut, JobStatusRejected, JobStatusCancelled:
+		return true
+	}
+	return false
+}
+
+// JobRole describes which side of a job this node is on
+type JobRole string
+
+const (
+	JobRoleSubmitter JobRole = "submitter" // this node sent the job
+	JobRoleExecutor  JobRole = "executor"  // this node ran the job
+)
+
+// JobAttempt is the outcome of sending a job to one peer
+type JobAttempt struct {
+	Attempt      int       `json:"attempt"`
+	TargetPeerID string    `json:"target_peer_id"`
+	Status       JobStatus `json:"status"`
+	Err          string    `json:"err,omitempty"`
+	StartedAt    time.Time `json:"started_at"`
+	EndedAt      time.Time `json:"ended_at"`
+}
+
+// JobRecord is the registry's view of a single job
+type JobRecord struct {
+	ID        string          `json:"id"`
+	Role      JobRole         `json:"role"`
+	Status    JobStatus       `json:"status"`
+	Request   DeployRequest   `json:"request"`
+	Response  *DeployResponse `json:"response,omitempty"`
+	Attempts  []JobAttempt    `json:"attempts,omitempty"` // one per peer the job was sent to
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// JobFilter narrows down a job listing. Zero values match everything.
+type JobFilter struct {
+	Status       JobStatus
+	TargetPeerID string
+	Since        time.Time
+	Until        time.Time
+	Offset       int
+	Limit        int
+}
+
+// Match reports whether the record satisfies the filter, ignoring pagination
+func (f JobFilter) Match(record JobRecord) bool {
+	if f.Status != "" && record.Status != f.Status {
+		return false
+	}
+	if f.TargetPeerID != "" && record.Request.TargetPeerID != f.TargetPeerID {
+		return false
+	}
+	if !f.Since.IsZero() && record.CreatedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && record.CreatedAt.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Resources describes the compute a job needs from the peer running it
+type Resources struct {
+	CPU         int     `json:"cpu"`          // cores
+	RAM         float64 `json:"ram"`          // GB
+	Disk        float64 `json:"disk"`         // GB
+	MaxDuration int     `json:"max_duration"` // seconds before the job is killed
+}
+
+func (r Resources) Validate() error {
+	if r.CPU < 0 {
+		return fmt.Errorf("cpu must not be negative")
+	}
+	if r.RAM < 0 {
+		return fmt.Errorf("ram must not be negative")
+	}
+	if r.Disk < 0 {
+		return fmt.Errorf("disk must not be negative")
+	}
+	if r.MaxDuration < 0 {
+		return fmt.Errorf("max_duration must not be negative")
+	}
+	return nil
+}
+
+// Add returns the cpu, ram and disk needed to run both jobs side by side
+func (r Resources) Add(other Resources) Resources {
+	return Resources{
+		CPU:  r.CPU + other.CPU,
+		RAM:  r.RAM + other.RAM,
+		Disk: r.Disk + other.Disk,
+	}
+}
+
+// Rejection explains why a peer refused to run a job
+type Rejection struct {
+	Reason    string  `json:"reason"`
+	Resource  string  `json:"resource,omitempty"` // cpu, ram, disk or max_duration
+	Requested float64 `json:"requested,omitempty"`
+	Available float64 `json:"available,omitempty"`
+
+	DeniedByPolicy bool `json:"denied_by_policy,omitempty"` // the node's policy doesn't let the submitter run the job
+}
+
+func (r Rejection) Error() string {
+	if r.Resource == "" {
+		return r.Reason
+	}
+	return fmt.Sprintf("%s: %s requested %g, available %g", r.Reason, r.Resource, r.Requested, r.Available)
+}
+
+// MaxStdinBytes is the largest stdin payload a job may carry
+const MaxStdinBytes = 1 << 20
+
+// envNamePattern matches the names the shell accepts for environment variables
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidateEnv checks that every variable has a name the program's shell would accept
+func ValidateEnv(env map[string]string) error {
+	for name := range env {
+		if !envNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+	}
+	return nil
+}
+
+// jobIDPattern matches job ids that are safe to name a directory after: no separators, and not "." or ".."
+var jobIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// ValidateJobID checks that the job id is a plain name, as workspaces and logs are kept under it
+func ValidateJobID(id string) error {
+	if !jobIDPattern.MatchString(id) {
+		return fmt.Errorf("invalid job id %q", id)
+	}
+	return nil
+}
+
+// ValidateWorkDir checks that the working directory stays inside the job's workspace
+func ValidateWorkDir(dir string) error {
+	if dir != "" && !filepath.IsLocal(dir) {
+		return fmt.Errorf("work_dir must be a relative path inside the job's workspace")
+	}
+	return nil
+}
+
+// File is a file shipped with a job or brought back from it, stored as a content-addressed blob
+type File struct {
+	Path string `json:"path"` // relative to the job's working directory
+	Size int64  `json:"size"` // 0 when referenced by a cid this node doesn't hold
+	CID  string `json:"cid"`
+}
+
+// ValidateFiles checks that every file has a cid and lands in its own place inside the job's working directory
+func ValidateFiles(files []File) error {
+	paths := make(map[string]bool, len(files))
+	for _, file := range files {
+		path := filepath.Clean(file.Path)
+		if file.Path == "" || !filepath.IsLocal(file.Path) {
+			return fmt.Errorf("%w: path %q must be relative to the working directory", ErrInvalidFile, file.Path)
+		}
+		if paths[path] {
+			return fmt.Errorf("%w: more than one file at %q", ErrInvalidFile, file.Path)
+		}
+		if _, err := cid.Decode(file.CID); err != nil || file.Size < 0 {
+			return fmt.Errorf("%w: bad cid or size for %q", ErrInvalidFile, file.Path)
+		}
+		paths[path] = true
+	}
+	return nil
+}
+
+// ValidateArtifactPatterns checks that the patterns are valid globs inside the job's working directory
+func ValidateArtifactPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if !filepath.IsLocal(pattern) {
+			return fmt.Errorf("artifact pattern %q must be relative to the working directory", pattern)
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid artifact pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
 
 type ApiDeployRequest struct {
-	Program   string   `json:"program"`
-	Arguments []string `json:"arguments"`
+	Runtime   string            `json:"runtime,omitempty"` // executor to run the program with, "process" if empty
+	Program   string            `json:"program"`
+	Arguments []string          `json:"arguments"`
+	Resources Resources         `json:"resources"`
+	Env       map[string]string `json:"env,omitempty"`
+	WorkDir   string            `json:"work_dir,omitempty"` // relative to the job's workspace
+	Stdin     string            `json:"stdin,omitempty"`
+	Inputs    []File            `json:"inputs,omitempty"`    // files referenced by the cid of an earlier upload or artifact
+	Artifacts []string          `json:"artifacts,omitempty"` // globs of files to bring back, relative to the working directory
+	// RetryFailed also moves the job to another peer when the program ran and failed or timed out
+	RetryFailed bool `json:"retry_failed,omitempty"`
 }
 
 func (a ApiDeployRequest) Validate() error {
 	if a.Program == "" {
 		return fmt.Errorf("program is required")
 	}
-	return nil
+	if err := ValidateEnv(a.Env); err != nil {
+		return err
+	}
+	if err := ValidateWorkDir(a.WorkDir); err != nil {
+		return err
+	}
+	if err := ValidateArtifactPatterns(a.Artifacts); err != nil {
+		return err
+	}
+	if len(a.Stdin) > MaxStdinBytes {
+		return fmt.Errorf("stdin must not be larger than %d bytes", MaxStdinBytes)
+	}
+	return a.Resources.Validate()
 }
 
 type ApiAddPeerRequest struct {
@@ -26,18 +271,56 @@ func (a ApiAddPeerRequest) Validate() error {
 }
 
 type DeployRequest struct {
+	JobID        string   `json:"job_id"`
 	SourcePeerID string   `json:"source_peer_id"`
 	SourceAddrs  []string `json:"source_addrs"`
 	TargetPeerID string   `json:"target_peer_id"`
 
-	Program   string   `json:"program"`
-	Arguments []string `json:"arguments"`
+	Runtime   string            `json:"runtime,omitempty"` // executor to run the program with, "process" if empty
+	Program   string            `json:"program"`
+	Arguments []string          `json:"arguments"`
+	Resources Resources         `json:"resources"`
+	Env       map[string]string `json:"env,omitempty"`
+	WorkDir   string            `json:"work_dir,omitempty"` // relative to the job's workspace
+	Stdin     string            `json:"stdin,omitempty"`
+	Inputs    []File            `json:"inputs,omitempty"`    // fetched from the submitter or other peers before the job runs
+	Artifacts []string          `json:"artifacts,omitempty"` // globs of files to bring back, relative to the working directory
+	// RetryFailed also moves the job to another peer when the program ran and failed or timed out
+	RetryFailed bool `json:"retry_failed,omitempty"`
+}
+
+// Validate checks a request received from another peer the way the API checks its own
+func (r DeployRequest) Validate() error {
+	if err := ValidateJobID(r.JobID); err != nil {
+		return err
+	}
+	if r.Program == "" {
+		return fmt.Errorf("program is required")
+	}
+	if err := ValidateEnv(r.Env); err != nil {
+		return err
+	}
+	if err := ValidateWorkDir(r.WorkDir); err != nil {
+		return err
+	}
+	if err := ValidateFiles(r.Inputs); err != nil {
+		return err
+	}
+	if err := ValidateArtifactPatterns(r.Artifacts); err != nil {
+		return err
+	}
+	if len(r.Stdin) > MaxStdinBytes {
+		return fmt.Errorf("stdin must not be larger than %d bytes", MaxStdinBytes)
+	}
+	return r.Resources.Validate()
 }
 
 type DeployResponse struct {
-	Err          string   `json:"err"`
-	SourcePeerID string   `json:"source_peer_id"`
-	SourceAddrs  []string `json:"source_addrs"`
+	JobID        string    `json:"job_id"`
+	Status       JobStatus `json:"status"`
+	Err          string    `json:"err"`
+	SourcePeerID string    `json:"source_peer_id"`
+	SourceAddrs  []string  `json:"source_addrs"`
 
 	Program   string   `json:"program"`
 	Arguments []string `json:"arguments"`
@@ -46,5 +329,40 @@ type DeployResponse struct {
 	TargetPeerID string   `json:"target_peer_id"`
 	TargetAddrs  []string `json:"target_addrs"`
 
-	Outputs []string `json:"outputs"`
+	ExitCode        *int             `json:"exit_code,omitempty"`  // unset if the program never ran, -1 if it was killed
+	Signal          string           `json:"signal,omitempty"`     // signal that killed the program, if any
+	OOMKilled       bool             `json:"oom_killed,omitempty"` // the program was killed for using more memory than it asked for
+	Usage           *pkg.Usage       `json:"usage,omitempty"`      // timing, cpu and memory of the program
+	Outputs         []pkg.OutputLine `json:"outputs"`
+	OutputTruncated bool             `json:"output_truncated,omitempty"`
+	Artifacts       []File           `json:"artifacts,omitempty"`     // files matching the request's artifact patterns
+	ArtifactsErr    string           `json:"artifacts_err,omitempty"` // why some artifacts couldn't be collected or fetched
+	ElapsedMs       int64            `json:"elapsed_ms"`              // wall time the program ran for
+	Rejection       *Rejection       `json:"rejection,omitempty"`
+}
+
+// LogEntry is a piece of a job's output, streamed while the job runs
+type LogEntry struct {
+	Seq    int       `json:"seq"`
+	Stream string    `json:"stream"` // stdout, stderr, or pull for the progress of pulling the job's image
+	Text   string    `json:"text"`
+	Time   time.Time `json:"time"`
+}
+
+// Capabilities is the snapshot of what a node can run, advertised periodically to its peers
+type Capabilities struct {
+	PeerID      string               `json:"peer_id"`
+	Compute     pkg.AvailableCompute `json:"compute"`
+	OS          string               `json:"os"`
+	Arch        string               `json:"arch"`
+	Runtimes    []string             `json:"runtimes"`  // interpreters and tools found on the node's PATH
+	Executors   []string             `json:"executors"` // runtimes jobs may name to run on the node
+	RunningJobs int                  `json:"running_jobs"`
+	Timestamp   time.Time            `json:"timestamp"`
+}
+
+// SignedCapabilities carries a JSON encoded Capabilities signed with the advertising peer's key
+type SignedCapabilities struct {
+	Payload   []byte `json:"payload"`
+	Signature []byte `json:"signature"`
 }
@@ -3,26 +3,82 @@ package main
 import (
 	"context"
 	"log"
+	"runtime"
+	"time"
 
 	"nunet/app"
+	"nunet/app/job"
+	"nunet/app/sandbox"
 	"nunet/pkg"
 )
 
 const (
 	defaultTopicName = "container-deployment-12223-nnddd" // Topic for deployment messages
 	defaultPort      = 8080                               // REST API port
+	defaultScheduler = job.StrategyLeastLoaded            // Strategy for picking the target peer
+
+	defaultAdvertiseInterval = 30  // Seconds between capability advertisements
+	defaultMaxJobDuration    = 300 // Longest run time, in seconds, a job may ask for
+	defaultMaxJobRetries     = 2   // Other peers to try when a job doesn't succeed
+	defaultResponseTimeout   = 600 // Seconds to wait for a result when a job sets no max duration
+	defaultMaxQueuedJobs     = 16  // Jobs waiting for a slot before new ones are turned away
+
+	defaultMaxOutputBytes     = pkg.DefaultMaxOutputBytes // Output of a job kept before the rest is dropped
+	defaultWorkspaceQuotaMB   = 1024                      // Disk a job may use when it requests none
+	defaultWorkspaceRetention = 0                         // Seconds a workspace is kept after its job ends
+	defaultMaxInputMB         = 1024                      // Size of the files a job may carry
+	defaultMaxArtifactMB      = 1024                      // Size of the files a job may bring back
+	defaultBlobRetention      = 86400                     // Seconds an unused input file or artifact is kept
+	defaultJobRetention       = 86400                     // Seconds a finished job and its logs are kept
+	defaultMaxFinishedJobs    = 1000                      // Finished jobs kept before the oldest are forgotten
+	defaultWasmMaxMemoryMB    = 512                       // Memory a wasm module may grow to
+	defaultWasmMaxCalls       = 10_000_000_000            // Function calls a wasm module may make
 )
 
 func main() {
+	// Set up the sandbox instead when started to run a sandboxed job
+	sandbox.Init()
+
 	// Create a new context
 	ctx := context.Background()
 
 	// Read environment variables for configuration
-	topicName := pkg.GetEnvOrDefault("TOPIC_NAME", defaultTopicName)
-	port := pkg.GetEnvOrDefaultInt("PORT", defaultPort)
+	config := app.Config{
+		TopicName: pkg.GetEnvOrDefault("TOPIC_NAME", defaultTopicName),
+		Port:      pkg.GetEnvOrDefaultInt("PORT", defaultPort),
+		Scheduler: pkg.GetEnvOrDefault("SCHEDULER", defaultScheduler),
+
+		AdvertiseInterval:  time.Duration(pkg.GetEnvOrDefaultInt("ADVERTISE_INTERVAL", defaultAdvertiseInterval)) * time.Second,
+		MaxJobDuration:     time.Duration(pkg.GetEnvOrDefaultInt("MAX_JOB_DURATION", defaultMaxJobDuration)) * time.Second,
+		MaxJobRetries:      pkg.GetEnvOrDefaultInt("MAX_JOB_RETRIES", defaultMaxJobRetries),
+		ResponseTimeout:    time.Duration(pkg.GetEnvOrDefaultInt("RESPONSE_TIMEOUT", defaultResponseTimeout)) * time.Second,
+		MaxConcurrentJobs:  pkg.GetEnvOrDefaultInt("MAX_CONCURRENT_JOBS", runtime.NumCPU()),
+		MaxQueuedJobs:      pkg.GetEnvOrDefaultInt("MAX_QUEUED_JOBS", defaultMaxQueuedJobs),
+		MaxOutputBytes:     pkg.GetEnvOrDefaultInt("MAX_OUTPUT_BYTES", defaultMaxOutputBytes),
+		EnvAllow:           pkg.GetEnvOrDefaultList("JOB_ENV_ALLOW", nil),
+		EnvDeny:            pkg.GetEnvOrDefaultList("JOB_ENV_DENY", job.DefaultEnvDeny),
+		WorkspaceDir:       pkg.GetEnvOrDefault("WORKSPACE_DIR", ""),
+		WorkspaceQuota:     int64(pkg.GetEnvOrDefaultInt("WORKSPACE_QUOTA_MB", defaultWorkspaceQuotaMB)) << 20,
+		WorkspaceRetention: time.Duration(pkg.GetEnvOrDefaultInt("WORKSPACE_RETENTION", defaultWorkspaceRetention)) * time.Second,
+		BlobDir:            pkg.GetEnvOrDefault("BLOB_DIR", ""),
+		BlobRetention:      time.Duration(pkg.GetEnvOrDefaultInt("BLOB_RETENTION", defaultBlobRetention)) * time.Second,
+		MaxInputBytes:      int64(pkg.GetEnvOrDefaultInt("MAX_INPUT_MB", defaultMaxInputMB)) << 20,
+		MaxArtifactBytes:   int64(pkg.GetEnvOrDefaultInt("MAX_ARTIFACT_MB", defaultMaxArtifactMB)) << 20,
+		JobRetention:       time.Duration(pkg.GetEnvOrDefaultInt("JOB_RETENTION", defaultJobRetention)) * time.Second,
+		MaxFinishedJobs:    pkg.GetEnvOrDefaultInt("MAX_FINISHED_JOBS", defaultMaxFinishedJobs),
+		WasmMaxMemoryBytes: int64(pkg.GetEnvOrDefaultInt("WASM_MAX_MEMORY_MB", defaultWasmMaxMemoryMB)) << 20,
+		WasmMaxCalls:       pkg.GetEnvOrDefaultUint64("WASM_MAX_CALLS", defaultWasmMaxCalls),
+		ContainerRuntime:   pkg.GetEnvOrDefault("CONTAINER_RUNTIME", ""),
+		Sandbox:            pkg.GetEnvOrDefaultBool("SANDBOX", false),
+		SandboxNetwork:     pkg.GetEnvOrDefaultBool("SANDBOX_NETWORK", false),
+		SandboxSeccomp:     pkg.GetEnvOrDefaultBool("SANDBOX_SECCOMP", true),
+		Cgroups:            pkg.GetEnvOrDefaultBool("CGROUPS", true),
+		CgroupParent:       pkg.GetEnvOrDefault("CGROUP_PARENT", ""),
+		PolicyFile:         pkg.GetEnvOrDefault("POLICY_FILE", ""),
+	}
 
 	// Run the application
-	if err := app.Run(ctx, topicName, port); err != nil {
+	if err := app.Run(ctx, config); err != nil {
 		log.Fatal("failed to run application: %w", err)
 	}
 }
@@ -0,0 +1,161 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxOutputBytes is how much output is kept when a command sets no limit, counted as encoded in JSON
+const DefaultMaxOutputBytes = 1 << 20
+
+// maxLineBytes bounds a line still waiting for its newline; longer lines are split
+const maxLineBytes = 64 << 10
+
+// OutputLine is a line written by a command, without its trailing newline
+type OutputLine struct {
+	Stream string    `json:"stream"` // stdout or stderr
+	Text   string    `json:"text"`
+	Time   time.Time `json:"time"`
+}
+
+// Capture collects the output of a command line by line, in the order it was
+// written across streams, and stops keeping lines once maxBytes is reached.
+// Lines are counted as encoded in JSON, so the kept output fits in maxBytes of
+// a job's response however short its lines are. It is safe for concurrent use.
+type Capture struct {
+	mu        sync.Mutex
+	maxBytes  int
+	size      int
+	lines     []OutputLine
+	partial   map[string][]byte // stream -> bytes written since its last newline
+	truncated bool
+	dropped   int
+	onLine    func(OutputLine)
+}
+
+// NewCapture creates a capture keeping up to maxBytes of output. onLine, when set,
+// is called with each kept line, in order, as soon as the line is complete.
+func NewCapture(maxBytes int, onLine func(OutputLine)) *Capture {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxOutputBytes
+	}
+	return &Capture{
+		maxBytes: maxBytes,
+		partial:  make(map[string][]byte),
+		onLine:   onLine,
+	}
+}
+
+// Writer returns a writer recording everything written to it as output of the given stream
+func (c *Capture) Writer(stream string) io.Writer {
+	return captureWriter{capture: c, stream: stream}
+}
+
+type captureWriter struct {
+	capture *Capture
+	stream  string
+}
+
+func (w captureWriter) Write(p []byte) (int, error) {
+	w.capture.write(w.stream, p)
+	return len(p), nil
+}
+
+func (c *Capture) write(stream string, p []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	buf := append(c.partial[stream], p...)
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			break
+		}
+		c.add(stream, buf[:i])
+		buf = buf[i+1:]
+	}
+	for len(buf) > maxLineBytes {
+		c.add(stream, buf[:maxLineBytes])
+		buf = buf[maxLineBytes:]
+	}
+	c.partial[stream] = append(c.partial[stream][:0], buf...)
+}
+
+// add records a complete line. The caller holds c.mu.
+func (c *Capture) add(stream string, text []byte) {
+	if !c.truncated {
+		line := OutputLine{
+			Stream: stream,
+			Text:   strings.TrimSuffix(string(text), "\r"),
+			Time:   time.Now().UTC(),
+		}
+		if size := encodedSize(line.Stream, line.Text); c.size+size <= c.maxBytes {
+			c.size += size
+			c.emit(line)
+			return
+		}
+
+		c.truncated = true
+		c.emit(OutputLine{
+			Stream: stream,
+			Text:   fmt.Sprintf("[output truncated: limit of %d bytes reached]", c.maxBytes),
+			Time:   time.Now().UTC(),
+		})
+	}
+	c.dropped += len(text) + 1 // count the newline
+}
+
+// lineOverhead is the room a line takes in a list of lines encoded in JSON besides its
+// stream and text, with the longest timestamp and the comma before the next line
+var lineOverhead = len(`{"stream":"","text":"","time":"2006-01-02T15:04:05.999999999Z"},`)
+
+// encodedSize is the most room a line takes in a list of lines encoded in JSON
+func encodedSize(stream, text string) int {
+	data, _ := json.Marshal(text)
+	return lineOverhead + len(stream) + len(data) - 2 // the quotes are counted in lineOverhead
+}
+
+func (c *Capture) emit(line OutputLine) {
+	c.lines = append(c.lines, line)
+	if c.onLine != nil {
+		c.onLine(line)
+	}
+}
+
+// Flush records the output left without a trailing newline
+func (c *Capture) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	streams := make([]string, 0, len(c.partial))
+	for stream, buf := range c.partial {
+		if len(buf) > 0 {
+			streams = append(streams, stream)
+		}
+	}
+	sort.Strings(streams)
+	for _, stream := range streams {
+		c.add(stream, c.partial[stream])
+		delete(c.partial, stream)
+	}
+}
+
+// Lines returns the lines recorded so far, including the truncation marker if any
+func (c *Capture) Lines() []OutputLine {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]OutputLine(nil), c.lines...)
+}
+
+// Truncated reports whether output was dropped and how many bytes of it
+func (c *Capture) Truncated() (bool, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.truncated, c.dropped
+}
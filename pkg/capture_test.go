@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCaptureSplitsLines(t *testing.T) {
+	var streamed []string
+	capture := NewCapture(0, func(line OutputLine) { streamed = append(streamed, line.Text) })
+	stdout, stderr := capture.Writer(StreamStdout), capture.Writer(StreamStderr)
+
+	fmt.Fprint(stdout, "one\ntw")
+	fmt.Fprint(stderr, "oops\r\n")
+	fmt.Fprint(stdout, "o\nthree")
+	capture.Flush()
+
+	lines := capture.Lines()
+	require.Len(t, lines, 4)
+	assert.Equal(t, OutputLine{Stream: StreamStdout, Text: "one", Time: lines[0].Time}, lines[0])
+	assert.Equal(t, OutputLine{Stream: StreamStderr, Text: "oops", Time: lines[1].Time}, lines[1])
+	assert.Equal(t, OutputLine{Stream: StreamStdout, Text: "two", Time: lines[2].Time}, lines[2])
+	assert.Equal(t, OutputLine{Stream: StreamStdout, Text: "three", Time: lines[3].Time}, lines[3])
+	assert.Equal(t, []string{"one", "oops", "two", "three"}, streamed)
+}
+
+func TestCaptureTruncates(t *testing.T) {
+	capture := NewCapture(encodedSize(StreamStdout, "12345")+encodedSize(StreamStdout, "6789"), nil)
+	fmt.Fprint(capture.Writer(StreamStdout), "12345\n6789\nabc\ndef\n")
+
+	lines := capture.Lines()
+	require.Len(t, lines, 3)
+	assert.Equal(t, "12345", lines[0].Text)
+	assert.Equal(t, "6789", lines[1].Text)
+	assert.Contains(t, lines[2].Text, "output truncated")
+
+	truncated, dropped := capture.Truncated()
+	assert.True(t, truncated)
+	assert.Equal(t, 8, dropped)
+}
+
+func TestCaptureBoundsEncodedSize(t *testing.T) {
+	capture := NewCapture(64<<10, nil)
+	fmt.Fprint(capture.Writer(StreamStdout), strings.Repeat("y\n", 1<<20))
+	capture.Flush()
+
+	// short lines take far more room encoded than the bytes printed
+	lines := capture.Lines()
+	data, err := json.Marshal(lines[:len(lines)-1])
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(data), 64<<10)
+	assert.Contains(t, lines[len(lines)-1].Text, "output truncated")
+
+	truncated, dropped := capture.Truncated()
+	assert.True(t, truncated)
+	assert.Equal(t, 2<<20, dropped+2*(len(lines)-1))
+}
+
+func TestCaptureSplitsLongLines(t *testing.T) {
+	capture := NewCapture(0, nil)
+	fmt.Fprint(capture.Writer(StreamStdout), string(make([]byte, maxLineBytes+1)))
+	capture.Flush()
+
+	lines := capture.Lines()
+	require.Len(t, lines, 2)
+	assert.Len(t, lines[0].Text, maxLineBytes)
+	assert.Len(t, lines[1].Text, 1)
+}
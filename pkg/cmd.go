@@ -1,16 +1,92 @@
 package pkg
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
 	"strings"
 	"sync"
 	"time"
 )
 
-// RunCmd executes the given command with the provided arguments
-func RunCmd(name string, args ...string) (outputs []string, pid int, err error) {
+// ErrCommandTimeout is returned when a command is killed for running too long
+var ErrCommandTimeout = errors.New("command timed out")
 
+// ErrCommandCancelled is returned when a command is killed because its context was cancelled
+var ErrCommandCancelled = errors.New("command cancelled")
+
+// Output streams of a command
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+	StreamPull   = "pull" // progress of pulling what a job needs to start, such as its image
+)
+
+// Command describes a program to run
+type Command struct {
+	Name           string
+	Args           []string
+	Env            []string                         // KEY=value pairs, the node's environment is inherited if nil
+	Dir            string                           // working directory, the node's if empty
+	Stdin          io.Reader                        // optional, read by the program as its standard input
+	MaxOutputBytes int                              // output kept beyond this is dropped, DefaultMaxOutputBytes if unset
+	OnOutput       func(stream string, text string) // optional, called with each line of output as soon as it is complete
+	Setup          func(cmd *exec.Cmd) error        // optional, adjusts how the process is started, such as to sandbox it
+}
+
+// Usage is the time and memory a process consumed
+type Usage struct {
+	StartedAt   time.Time `json:"started_at"`
+	EndedAt     time.Time `json:"ended_at"`
+	WallMs      int64     `json:"wall_ms"`
+	UserCPUMs   int64     `json:"user_cpu_ms"`
+	SystemCPUMs int64     `json:"system_cpu_ms"`
+	MaxRSS      int64     `json:"max_rss"`               // peak resident set size in bytes, 0 where unknown
+	MemoryPeak  int64     `json:"memory_peak,omitempty"` // peak memory of the job's cgroup in bytes, page cache included
+}
+
+// Result is what a command left behind
+type Result struct {
+	PID          int          `json:"pid"`
+	ExitCode     int          `json:"exit_code"`        // -1 if the process was killed or never started
+	Signal       string       `json:"signal,omitempty"` // signal that killed the process, if any
+	Usage        *Usage       `json:"usage,omitempty"`  // unset if the process never started
+	Lines        []OutputLine `json:"lines"`
+	Truncated    bool         `json:"truncated"`
+	DroppedBytes int          `json:"dropped_bytes"`
+}
+
+// RunCmd executes the given command with the provided arguments and captures its output line by line.
+// Once the context is done the process and its children are killed, and
+// ErrCommandTimeout or ErrCommandCancelled is returned depending on why.
+func RunCmd(ctx context.Context, command Command) (Result, error) {
+	process, err := StartCmd(ctx, command)
+	if err != nil {
+		return Result{ExitCode: -1}, err
+	}
+	return process.Wait()
+}
+
+// Process is a command started by StartCmd
+type Process struct {
+	cmd     *exec.Cmd
+	capture *Capture
+	done    chan struct{}
+
+	mu         sync.Mutex
+	exited     bool
+	killReason error // why the process was killed, if it was
+
+	result Result
+	err    error
+}
+
+// StartCmd starts the command and returns without waiting for it to finish. Its output is
+// captured line by line; once the context is done the process and its children are killed.
+func StartCmd(ctx context.Context, command Command) (process *Process, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			fmt.Println("Recovered from panic")
@@ -18,73 +94,123 @@ func RunCmd(name string, args ...string) (outputs []string, pid int, err error)
 		}
 	}()
 
-	fmt.Printf("Executing command: %s %s\n", name, strings.Join(args, " "))
-	cmd := exec.Command(name, args...)
-
-	// get the outputs
-	outputs = []string{}
+	var onLine func(OutputLine)
+	if command.OnOutput != nil {
+		onLine = func(line OutputLine) { command.OnOutput(line.Stream, line.Text) }
+	}
+	capture := NewCapture(command.MaxOutputBytes, onLine)
 
-	// Attach the stdout and stderr pipes
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, 0, fmt.Errorf("error attaching stdout pipe: %w", err)
+	fmt.Printf("Executing command: %s %s\n", command.Name, strings.Join(command.Args, " "))
+	cmd := exec.Command(command.Name, command.Args...)
+	cmd.Env = command.Env
+	cmd.Dir = command.Dir
+	cmd.Stdin = command.Stdin
+	cmd.Stdout = capture.Writer(StreamStdout)
+	cmd.Stderr = capture.Writer(StreamStderr)
+	cmd.WaitDelay = time.Second // don't hang on pipes inherited by orphaned children
+	setProcessGroup(cmd)
+	if command.Setup != nil {
+		if err := command.Setup(cmd); err != nil {
+			return nil, fmt.Errorf("error setting up command: %w", err)
+		}
 	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return nil, 0, fmt.Errorf("error attaching stderr pipe: %w", err)
+
+	startedAt := time.Now().UTC()
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error starting command: %w", err)
 	}
 
-	wg := sync.WaitGroup{}
-	wg.Add(2)
-	// get the outputs
+	process = &Process{
+		cmd:     cmd,
+		capture: capture,
+		done:    make(chan struct{}),
+		result:  Result{PID: cmd.Process.Pid},
+	}
+	go process.wait(startedAt)
 	go func() {
-		defer wg.Done()
-		for {
-			buf := make([]byte, 1024)
-			n, err := stdout.Read(buf)
-			if n > 0 {
-				outputs = append(outputs, "Info: "+strings.TrimSpace(string(buf[:n])))
-			}
-			if err != nil {
-				break
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				process.kill(ErrCommandTimeout)
+			} else {
+				process.kill(ErrCommandCancelled)
 			}
+		case <-process.done:
 		}
 	}()
+	return process, nil
+}
 
-	go func() {
-		defer wg.Done()
-		for {
-			buf := make([]byte, 1024)
-			n, err := stderr.Read(buf)
-			if n > 0 {
-				outputs = append(outputs, "Error: "+strings.TrimSpace(string(buf[:n])))
-			}
-			if err != nil {
-				break
-			}
-		}
-	}()
+// wait reaps the process and records its result
+func (p *Process) wait(startedAt time.Time) {
+	err := p.cmd.Wait()
+	p.result.Usage = usageOf(p.cmd.ProcessState, startedAt, time.Now().UTC())
+	p.result.ExitCode, p.result.Signal = exitStatus(p.cmd.ProcessState)
+	p.capture.Flush()
+	p.result.Lines = p.capture.Lines()
+	p.result.Truncated, p.result.DroppedBytes = p.capture.Truncated()
 
-	if err := cmd.Start(); err != nil {
-		return nil, 0, fmt.Errorf("error starting command: %w", err)
+	p.mu.Lock()
+	p.exited = true
+	switch {
+	case p.killReason != nil:
+		p.err = p.killReason
+	case err != nil:
+		p.err = fmt.Errorf("error waiting for command to finish: %w", err)
+	default:
+		fmt.Println("Command executed successfully")
 	}
+	p.mu.Unlock()
+	close(p.done)
+}
 
-	done := make(chan error)
-	go func() {
-		done <- cmd.Wait()
-	}()
+// kill stops the process and its children, unless it already exited
+func (p *Process) kill(reason error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	defer wg.Wait()
-	select {
-	case <-time.After(time.Minute / 2):
-		cmd.Process.Kill()
-		return outputs, 0, fmt.Errorf("command timed out")
-	case err := <-done:
-		if err != nil {
-			return outputs, 0, fmt.Errorf("error waiting for command to finish: %w", err)
-		}
+	if p.exited || p.killReason != nil {
+		return
 	}
+	p.killReason = reason
+	killProcessGroup(p.cmd)
+}
+
+// PID returns the process id
+func (p *Process) PID() int {
+	return p.result.PID
+}
+
+// Kill stops the process and its children; Wait then returns ErrCommandCancelled
+func (p *Process) Kill() {
+	p.kill(ErrCommandCancelled)
+}
+
+// Wait blocks until the process exits and returns what it left behind
+func (p *Process) Wait() (Result, error) {
+	<-p.done
+	return p.result, p.err
+}
+
+// usageOf returns the resources used by a process that ran between startedAt and endedAt
+func usageOf(state *os.ProcessState, startedAt, endedAt time.Time) *Usage {
+	usage := &Usage{
+		StartedAt: startedAt,
+		EndedAt:   endedAt,
+		WallMs:    endedAt.Sub(startedAt).Milliseconds(),
+	}
+	if state != nil {
+		usage.UserCPUMs = state.UserTime().Milliseconds()
+		usage.SystemCPUMs = state.SystemTime().Milliseconds()
+		usage.MaxRSS = maxRSS(state)
+	}
+	return usage
+}
 
-	fmt.Println("Command executed successfully")
-	return outputs, cmd.ProcessState.Pid(), nil
+// exitStatus returns the exit code of a process and the signal that killed it, if any
+func exitStatus(state *os.ProcessState) (int, string) {
+	if state == nil {
+		return -1, ""
+	}
+	return state.ExitCode(), exitSignal(state)
 }
@@ -0,0 +1,97 @@
+package pkg
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCmdCapturesOutputInOrder(t *testing.T) {
+	result, err := RunCmd(context.Background(), Command{
+		Name: "sh",
+		Args: []string{"-c", "echo out; sleep 0.1; echo err >&2; sleep 0.1; printf tail"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.ExitCode)
+	assert.NotZero(t, result.PID)
+	require.NotNil(t, result.Usage)
+	assert.GreaterOrEqual(t, result.Usage.WallMs, int64(200))
+	assert.True(t, result.Usage.EndedAt.After(result.Usage.StartedAt))
+	assert.Positive(t, result.Usage.MaxRSS)
+
+	require.Len(t, result.Lines, 3)
+	assert.Equal(t, StreamStdout, result.Lines[0].Stream)
+	assert.Equal(t, "out", result.Lines[0].Text)
+	assert.Equal(t, StreamStderr, result.Lines[1].Stream)
+	assert.Equal(t, "err", result.Lines[1].Text)
+	assert.Equal(t, "tail", result.Lines[2].Text)
+}
+
+func TestRunCmdEnvDirAndStdin(t *testing.T) {
+	dir := t.TempDir()
+	result, err := RunCmd(context.Background(), Command{
+		Name:  "sh",
+		Args:  []string{"-c", "echo $GREETING; pwd; cat"},
+		Env:   []string{"GREETING=hello"},
+		Dir:   dir,
+		Stdin: strings.NewReader("from stdin\n"),
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Lines, 3)
+	assert.Equal(t, "hello", result.Lines[0].Text)
+	assert.Equal(t, dir, result.Lines[1].Text)
+	assert.Equal(t, "from stdin", result.Lines[2].Text)
+}
+
+func TestRunCmdExitCode(t *testing.T) {
+	result, err := RunCmd(context.Background(), Command{Name: "sh", Args: []string{"-c", "echo failing; exit 3"}})
+	assert.Error(t, err)
+	assert.Equal(t, 3, result.ExitCode)
+	assert.Empty(t, result.Signal)
+	assert.NotZero(t, result.PID)
+	require.Len(t, result.Lines, 1)
+	assert.Equal(t, "failing", result.Lines[0].Text)
+}
+
+func TestRunCmdTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	result, err := RunCmd(ctx, Command{Name: "sleep", Args: []string{"10"}})
+	assert.ErrorIs(t, err, ErrCommandTimeout)
+	assert.Equal(t, -1, result.ExitCode)
+	assert.Equal(t, "killed", result.Signal)
+	assert.NotZero(t, result.PID)
+	assert.NotNil(t, result.Usage)
+}
+
+func TestStartCmdKill(t *testing.T) {
+	process, err := StartCmd(context.Background(), Command{Name: "sh", Args: []string{"-c", "echo started; sleep 10"}})
+	require.NoError(t, err)
+	assert.NotZero(t, process.PID())
+
+	time.Sleep(100 * time.Millisecond)
+	process.Kill()
+	result, err := process.Wait()
+	assert.ErrorIs(t, err, ErrCommandCancelled)
+	assert.Equal(t, -1, result.ExitCode)
+	assert.Equal(t, process.PID(), result.PID)
+	require.Len(t, result.Lines, 1)
+	assert.Equal(t, "started", result.Lines[0].Text)
+
+	process.Kill() // no-op once exited
+	_, err = process.Wait()
+	assert.ErrorIs(t, err, ErrCommandCancelled)
+}
+
+func TestRunCmdNotFound(t *testing.T) {
+	result, err := RunCmd(context.Background(), Command{Name: "nunet-no-such-program"})
+	assert.Error(t, err)
+	assert.Zero(t, result.PID)
+	assert.Equal(t, -1, result.ExitCode)
+	assert.Nil(t, result.Usage)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/libp2p/go-libp2p/core/host"
 )
@@ -24,6 +25,41 @@ func GetEnvOrDefaultInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func GetEnvOrDefaultUint64(key string, defaultValue uint64) uint64 {
+	if value := os.Getenv(key); value != "" {
+		if uintValue, err := strconv.ParseUint(value, 10, 64); err == nil {
+			return uintValue
+		}
+	}
+	return defaultValue
+}
+
+func GetEnvOrDefaultBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+// GetEnvOrDefaultList reads a comma separated list. Setting the variable to an empty
+// string yields an empty list rather than the default.
+func GetEnvOrDefaultList(key string, defaultValue []string) []string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue
+	}
+
+	var results []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			results = append(results, item)
+		}
+	}
+	return results
+}
+
 func PrintHostInfo(host host.Host) {
 	fmt.Println("Host ID:", host.ID())
 	for _, addr := range host.Addrs() {
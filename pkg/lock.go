@@ -0,0 +1,23 @@
+package pkg
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrLocked is returned when another process holds the lock
+var ErrLocked = errors.New("locked by another process")
+
+// Lock takes an exclusive lock on the file at path, creating it if needed. The lock is
+// held until release is called or the process exits.
+func Lock(path string) (release func(), err error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return func() { file.Close() }, nil
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package pkg
+
+import "os"
+
+// lockFile is a no-op where advisory locks aren't supported
+func lockFile(file *os.File) error { return nil }
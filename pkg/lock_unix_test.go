@@ -0,0 +1,25 @@
+//go:build unix
+
+package pkg
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dir.lock")
+
+	release, err := Lock(path)
+	require.NoError(t, err)
+	_, err = Lock(path)
+	assert.ErrorIs(t, err, ErrLocked)
+
+	release()
+	release, err = Lock(path)
+	require.NoError(t, err)
+	release()
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
 	"github.com/shirou/gopsutil/v3/mem"
 )
 
@@ -16,9 +17,14 @@ type AvailableCompute struct {
 
 	FreeCPUCores int     `json:"free_cpu_cores"`
 	FreeRAM      float64 `json:"free_ram"`
+
+	TotalDisk float64 `json:"total_disk"`
+	FreeDisk  float64 `json:"free_disk"`
 }
 
-func GetComputeAvailable() (*AvailableCompute, error) {
+// GetComputeAvailable measures the node's compute, with the disk measured on the volume
+// holding diskPath, where jobs run
+func GetComputeAvailable(diskPath string) (*AvailableCompute, error) {
 	// Get CPU information
 	cpuInfo, err := cpu.Info()
 	if err != nil {
@@ -34,6 +40,14 @@ func GetComputeAvailable() (*AvailableCompute, error) {
 	totalRAM := float64(vmem.Total) / 1024 / 1024 / 1024
 	freeRAM := float64(vmem.Free) / 1024 / 1024 / 1024
 
+	// Get disk information for the volume jobs run on
+	usage, err := disk.Usage(diskPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting disk information")
+	}
+	totalDisk := float64(usage.Total) / 1024 / 1024 / 1024
+	freeDisk := float64(usage.Free) / 1024 / 1024 / 1024
+
 	// Calculate total CPU speed in GHz
 	totalCPUGhz := cpuInfo[0].Mhz / 1000
 
@@ -45,5 +59,8 @@ func GetComputeAvailable() (*AvailableCompute, error) {
 
 		FreeCPUCores: runtime.NumCPU(),
 		FreeRAM:      freeRAM,
+
+		TotalDisk: totalDisk,
+		FreeDisk:  freeDisk,
 	}, nil
 }
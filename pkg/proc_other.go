@@ -0,0 +1,29 @@
+//go:build !unix
+
+package pkg
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op where process groups aren't supported
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the command; its children are left running where process groups aren't supported
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
+
+// exitSignal is always empty where wait statuses don't carry signals
+func exitSignal(state *os.ProcessState) string {
+	return ""
+}
+
+// maxRSS is unknown where rusage isn't available
+func maxRSS(state *os.ProcessState) int64 {
+	return 0
+}